@@ -8,15 +8,18 @@ package mktmpio
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 
-	"github.com/mktmpio/go-mktmpio/stdcopy"
 	"golang.org/x/net/websocket"
 )
 
@@ -28,25 +31,70 @@ import _ "crypto/sha512" // side-effect only
 // Client provides authenticated API access for creating, listing, and destroying
 // database servers.
 type Client struct {
-	token     string
-	url       string
-	UserAgent string
-	logger    *log.Logger
+	token      string
+	url        string
+	UserAgent  string
+	logger     *log.Logger
+	httpClient *http.Client
+	dialer     Dialer
+	retry      RetryPolicy
+	keepalive  KeepalivePolicy
 }
 
 var devNull = log.New(ioutil.Discard, "", 0)
 
+// Option configures optional behaviour of a Client created by NewClient.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for API requests. If hc uses an
+// *http.Transport, its Proxy and TLSClientConfig settings are also applied to
+// the websocket connections used for remote shells. The connections hc makes
+// are up to its Transport, so a Dialer set with WithDialer is not used for
+// them.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithDialer sets the Dialer used for opening network connections: those
+// that remote shell websockets run over, readiness checks and, unless
+// WithHTTPClient is also given, API requests.
+func WithDialer(d Dialer) Option {
+	return func(c *Client) {
+		c.dialer = d
+	}
+}
+
 // NewClient creates a mktmpio Client using credentials loaded from the user
 // config stored in ~/.mktmpio.yml
-func NewClient(cfg *Config) (*Client, error) {
+func NewClient(cfg *Config, opts ...Option) (*Client, error) {
 	client := &Client{
 		token:     cfg.Token,
 		url:       cfg.URL,
 		UserAgent: "go-mktmpio",
+		retry:     DefaultRetryPolicy,
+		keepalive: DefaultKeepalivePolicy,
 	}
 	if client.url == "" {
 		client.url = MktmpioURL
 	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	switch {
+	case client.httpClient != nil:
+	case client.dialer != nil:
+		// make API requests over the same Dialer as everything else
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = client.dialer.DialContext
+		client.httpClient = &http.Client{Transport: t}
+	default:
+		client.httpClient = http.DefaultClient
+	}
+	if client.dialer == nil {
+		client.dialer = &net.Dialer{}
+	}
 	return client, nil
 }
 
@@ -55,6 +103,20 @@ func (c *Client) SetLogger(logger *log.Logger) {
 	c.logger = logger
 }
 
+func (c Client) http() *http.Client {
+	if c.httpClient == nil {
+		return http.DefaultClient
+	}
+	return c.httpClient
+}
+
+func (c Client) dial() Dialer {
+	if c.dialer == nil {
+		return &net.Dialer{}
+	}
+	return c.dialer
+}
+
 func (c Client) log() *log.Logger {
 	if c.logger == nil {
 		return devNull
@@ -64,51 +126,137 @@ func (c Client) log() *log.Logger {
 
 // NewRequest creates an http.Request based on the Client's configuration. The
 // created request object is suitable for passing to http.Client.Do()
-func (c Client) newRequest(method, path string) (*http.Request, error) {
-	req, err := http.NewRequest(method, c.url+path, nil)
+func (c Client) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
 	if req != nil {
+		req = req.WithContext(ctx)
 		req.Header.Set("Accept", "application/json")
 		req.Header.Set("User-Agent", c.UserAgent)
 		req.Header.Set("X-Auth-Token", c.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
 	}
 	return req, err
 }
 
-// Create creates a server of the type specified by `service`.
-func (c Client) rawRequest(method, path string) ([]byte, error) {
-	req, _ := c.newRequest(method, path)
-	resp, err := http.DefaultClient.Do(req)
+// rawRequest performs an API request and returns the raw response body. Any
+// response with a status other than 2xx results in an *APIError. Requests
+// that fail with transient errors are retried according to the Client's
+// RetryPolicy. POST requests are only considered safe to retry if they carry
+// an Idempotency-Key header.
+func (c Client) rawRequest(ctx context.Context, method, path string, header http.Header, reqBody []byte) ([]byte, error) {
+	var body []byte
+	idempotent := method != "POST" || header.Get(idempotencyKeyHeader) != ""
+	err := c.retry.withRetries(ctx, idempotent, func() error {
+		var err error
+		body, err = c.doRequest(ctx, method, path, header, reqBody)
+		return err
+	})
+	return body, err
+}
+
+// doRequest makes a single attempt at an API request.
+func (c Client) doRequest(ctx context.Context, method, path string, header http.Header, reqBody []byte) ([]byte, error) {
+	req, err := c.newRequest(ctx, method, path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.http().Do(req)
 	if err != nil {
 		c.log().Printf("req: %+v", req)
 		return nil, err
 	}
 	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		c.log().Printf("res: %d %s", resp.StatusCode, body)
+		return nil, newAPIError(req, resp, body)
+	}
+	return body, nil
 }
 
-// Create creates a server of the type specified by `service`.
-func (c Client) jsonRequest(method, path string, instance interface{}) error {
-	body, err := c.rawRequest(method, path)
+// jsonRequest performs an API request, sending `params` as a JSON request body
+// if it is not nil, and decodes the JSON response into `instance`.
+func (c Client) jsonRequest(ctx context.Context, method, path string, header http.Header, params, instance interface{}) error {
+	var reqBody []byte
+	if params != nil {
+		var err error
+		if reqBody, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	body, err := c.rawRequest(ctx, method, path, header, reqBody)
 	if err != nil || instance == nil {
 		return err
 	}
-	err = json.Unmarshal(body, instance)
-	if err != nil {
+	if err = json.Unmarshal(body, instance); err != nil {
 		c.log().Printf("res: %s", body)
-		if bytes.HasPrefix(body, []byte(`{"error":`)) {
-			err = errors.New(string(body[10 : len(body)-2]))
-		} else {
-			err = errors.New(err.Error() + string(body))
-		}
+		err = fmt.Errorf("invalid response from %s %s: %s: %s", method, path, err, body)
 	}
 	return err
 }
 
 // Create creates a server of the type specified by `service`.
 func (c Client) Create(service string) (*Instance, error) {
-	instance := &Instance{client: c}
-	reqURL := "/new/" + service
-	if err := c.jsonRequest("POST", reqURL, instance); err != nil {
+	return c.CreateContext(context.Background(), service)
+}
+
+// CreateContext is like Create but aborts the request if ctx is done before
+// the server has responded.
+func (c Client) CreateContext(ctx context.Context, service string) (*Instance, error) {
+	return c.CreateWithKeyContext(ctx, service, NewIdempotencyKey())
+}
+
+// CreateWithKey is like Create but uses the given idempotency key instead of
+// generating a new one. Creating an instance with a key that has already been
+// used returns the instance created by the original request instead of a new
+// one, which makes it safe to repeat a Create whose outcome is unknown.
+func (c Client) CreateWithKey(service, key string) (*Instance, error) {
+	return c.CreateWithKeyContext(context.Background(), service, key)
+}
+
+// CreateWithKeyContext is like CreateWithKey but aborts the request if ctx is
+// done before the server has responded.
+func (c Client) CreateWithKeyContext(ctx context.Context, service, key string) (*Instance, error) {
+	return c.CreateWithOptionsContext(ctx, service, CreateOptions{IdempotencyKey: key})
+}
+
+// CreateWithOptions is like Create but allows specifying the version, size,
+// lifetime and other settings of the new instance. The options are validated
+// before any request is made.
+func (c Client) CreateWithOptions(service string, opts CreateOptions) (*Instance, error) {
+	return c.CreateWithOptionsContext(context.Background(), service, opts)
+}
+
+// CreateWithOptionsContext is like CreateWithOptions but aborts the request
+// if ctx is done before the server has responded.
+func (c Client) CreateWithOptionsContext(ctx context.Context, service string, opts CreateOptions) (*Instance, error) {
+	return c.create(ctx, "/new/"+service, opts)
+}
+
+// create requests a new instance from the API endpoint at reqURL.
+func (c Client) create(ctx context.Context, reqURL string, opts CreateOptions) (*Instance, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.IdempotencyKey == "" {
+		opts.IdempotencyKey = NewIdempotencyKey()
+	}
+	instance := &Instance{client: c, IdempotencyKey: opts.IdempotencyKey}
+	header := http.Header{}
+	header.Set(idempotencyKeyHeader, opts.IdempotencyKey)
+	if err := c.jsonRequest(ctx, "POST", reqURL, header, opts.params(), instance); err != nil {
 		return nil, err
 	}
 	if len(instance.Error) > 0 {
@@ -119,19 +267,75 @@ func (c Client) Create(service string) (*Instance, error) {
 
 // List generates retrieves a list of curently running instances
 func (c Client) List() ([]Instance, error) {
+	return c.ListContext(context.Background())
+}
+
+// ListContext is like List but aborts the request if ctx is done before the
+// server has responded.
+func (c Client) ListContext(ctx context.Context) ([]Instance, error) {
 	reqURL := "/i"
 	instances := []Instance{}
-	err := c.jsonRequest("GET", reqURL, &instances)
+	err := c.jsonRequest(ctx, "GET", reqURL, nil, nil, &instances)
 	if err != nil {
 		return nil, err
 	}
+	for i := range instances {
+		instances[i].client = c
+	}
 	return instances, nil
 }
 
+// Get retrieves the current state of the instance identified by `id`. If the
+// instance does not exist, for example because it has already been destroyed,
+// the returned error satisfies IsNotFound.
+func (c Client) Get(id string) (*Instance, error) {
+	return c.GetContext(context.Background(), id)
+}
+
+// GetContext is like Get but aborts the request if ctx is done before the
+// server has responded.
+func (c Client) GetContext(ctx context.Context, id string) (*Instance, error) {
+	instance := &Instance{client: c}
+	if err := c.jsonRequest(ctx, "GET", "/i/"+id, nil, nil, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
 // Destroy shuts down and deletes the server identified by `id`.
 func (c Client) Destroy(id string) error {
+	return c.DestroyContext(context.Background(), id)
+}
+
+// DestroyContext is like Destroy but aborts the request if ctx is done before
+// the server has responded.
+func (c Client) DestroyContext(ctx context.Context, id string) error {
 	path := "/i/" + id
-	return c.jsonRequest("DELETE", path, nil)
+	return c.jsonRequest(ctx, "DELETE", path, nil, nil, nil)
+}
+
+// FindByIdempotencyKey returns the instance that was created using the given
+// idempotency key. This allows recovering an instance whose Create request
+// was interrupted, for example by a crash, before its response was received.
+// If no such instance exists, the returned error satisfies IsNotFound.
+func (c Client) FindByIdempotencyKey(key string) (*Instance, error) {
+	return c.FindByIdempotencyKeyContext(context.Background(), key)
+}
+
+// FindByIdempotencyKeyContext is like FindByIdempotencyKey but aborts the
+// request if ctx is done before the server has responded.
+func (c Client) FindByIdempotencyKeyContext(ctx context.Context, key string) (*Instance, error) {
+	reqURL := "/i?" + url.Values{"idempotencyKey": {key}}.Encode()
+	instances := []Instance{}
+	if err := c.jsonRequest(ctx, "GET", reqURL, nil, nil, &instances); err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, notFound("GET", "/i", "no instance with idempotency key "+key)
+	}
+	instance := &instances[0]
+	instance.client = c
+	return instance, nil
 }
 
 // AttachStdio creates a remote shell for the instance identified by `id` and
@@ -139,26 +343,19 @@ func (c Client) Destroy(id string) error {
 // stdout and stderr on that shell. This is for non-interactive shells, like one
 // would use for piping a script into a shell or for piping the output from.
 func (c Client) AttachStdio(id string) (io.WriteCloser, io.Reader, io.Reader, error) {
-	conn, err := c.attachWS(id, true)
+	return c.AttachStdioContext(context.Background(), id)
+}
+
+// AttachStdioContext is like AttachStdio but the shell is bound to ctx. When
+// ctx is done the websocket is closed and any pending reads and writes on the
+// returned streams fail with ctx.Err(). Use AttachStdioSession to also find
+// out why the shell ended.
+func (c Client) AttachStdioContext(ctx context.Context, id string) (io.WriteCloser, io.Reader, io.Reader, error) {
+	s, err := c.AttachStdioSession(ctx, id)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	inReader, inWriter := io.Pipe()
-	outReader, outWriter := io.Pipe()
-	errReader, errWriter := io.Pipe()
-	go func() {
-		// stdcopy is Docker's demuxer for their stdout/stderr multiplexed stream
-		stdcopy.StdCopy(outWriter, errWriter, conn)
-		errWriter.Close()
-		outWriter.Close()
-	}()
-	go func() {
-		io.Copy(conn, inReader)
-		// A cheap hack sentinel value to indicate EOF to the server without closing
-		// the actual connection. This would be so much easier with plain TCP :-(
-		conn.Write([]byte{255, 255, 255, 255})
-	}()
-	return inWriter, outReader, errReader, err
+	return s.Stdin, s.Stdout, s.Stderr, nil
 }
 
 // Attach creates a remote shell for the instance identified by `id` and then
@@ -166,10 +363,28 @@ func (c Client) AttachStdio(id string) (io.WriteCloser, io.Reader, io.Reader, er
 // bytes read from the channel will include TTY control sequences. This type of
 // connection is most appropriate for connecting directly to a local TTY.
 func (c Client) Attach(id string) (io.ReadWriteCloser, error) {
-	return c.attachWS(id, false)
+	return c.AttachContext(context.Background(), id)
 }
 
-func (c Client) attachWS(id string, stdio bool) (*websocket.Conn, error) {
+// AttachContext is like Attach but the shell is bound to ctx. When ctx is done
+// the connection is closed.
+func (c Client) AttachContext(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	return c.AttachTTY(ctx, id, TTYOptions{})
+}
+
+func (c Client) attachWS(ctx context.Context, id string, stdio bool) (*wsConn, error) {
+	params := url.Values{}
+	params.Set("id", id)
+	if stdio {
+		params.Set("stdio", "true")
+	} else {
+		params.Set("stdio", "false")
+	}
+	return c.openWS(ctx, params)
+}
+
+// openWS opens a websocket to the /ws endpoint with the given query params.
+func (c Client) openWS(ctx context.Context, params url.Values) (*wsConn, error) {
 	wsURL, err := url.Parse(c.url)
 	if err != nil {
 		c.log().Printf("error parsing url: %s: %s", c.url, err)
@@ -181,13 +396,6 @@ func (c Client) attachWS(id string, stdio bool) (*websocket.Conn, error) {
 		wsURL.Scheme = "ws"
 	}
 	wsURL.Path = "/ws"
-	params := url.Values{}
-	params.Set("id", id)
-	if stdio {
-		params.Set("stdio", "true")
-	} else {
-		params.Set("stdio", "false")
-	}
 	wsURL.RawQuery = params.Encode()
 	cfg, err := websocket.NewConfig(wsURL.String(), "http://localhost/")
 	if err != nil {
@@ -197,7 +405,10 @@ func (c Client) attachWS(id string, stdio bool) (*websocket.Conn, error) {
 	cfg.Header.Set("Accept", "application/json")
 	cfg.Header.Set("User-Agent", "go-mktmpio")
 	cfg.Header.Set("X-Auth-Token", c.token)
-	conn, err := websocket.DialConfig(cfg)
+	if params.Get("stdio") == "true" {
+		cfg.Protocol = []string{stdioProtocolV2}
+	}
+	conn, err := c.dialWS(ctx, cfg)
 	if err != nil {
 		c.log().Printf("error dialing websocket: %+v: %s", cfg, err)
 	} else {
@@ -205,3 +416,23 @@ func (c Client) attachWS(id string, stdio bool) (*websocket.Conn, error) {
 	}
 	return conn, err
 }
+
+// closeOnDone closes conn when ctx is done. The returned function stops
+// watching ctx and must be called once conn is no longer in use.
+func closeOnDone(ctx context.Context, conn io.Closer) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -5,11 +5,16 @@
 package mktmpio
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
 )
 
 var (
@@ -49,7 +54,7 @@ func TestNewClient(t *testing.T) {
 
 func TestClientRequest(t *testing.T) {
 	client, _ := NewClient(badURLConfig)
-	req, err := client.newRequest("", "")
+	req, err := client.newRequest(context.Background(), "", "", nil)
 	if err == nil || req != nil {
 		t.Error("client.newRequest should error when client has bad url", err, req)
 	}
@@ -239,6 +244,58 @@ func TestCreateDestroy(t *testing.T) {
 	}
 }
 
+func TestClientCreateContextCanceled(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+	client, _ := NewClient(testConfig)
+	client.url = ts.URL
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	instance, err := client.CreateContext(ctx, "db")
+	if err == nil {
+		t.Error("client.CreateContext did not return an error")
+	}
+	if instance != nil {
+		t.Error("client.CreateContext returned an instance:", instance)
+	}
+	if ctx.Err() == nil {
+		t.Error("client.CreateContext returned before the deadline:", err)
+	}
+}
+
+func TestAttachStdioContextCanceled(t *testing.T) {
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		// never respond, just wait for the client to go away
+		io.Copy(ioutil.Discard, ws)
+	}))
+	defer ts.Close()
+	client, _ := NewClient(testConfig)
+	client.url = ts.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	_, stdout, _, err := client.AttachStdioContext(ctx, "12345678")
+	if err != nil {
+		t.Fatal("Error attaching to mock server:", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := stdout.Read(make([]byte, 64))
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Error("stdout read did not fail with context.Canceled:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("stdout read was not unblocked by canceled context")
+	}
+}
+
 func TestAttach(t *testing.T) {
 	cfg := LoadConfig()
 	if cfg.Token == "" {
@@ -341,3 +398,35 @@ func tty(b []byte) string {
 	}
 	return s
 }
+
+func TestClientGet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Error("wrong method:", r.Method)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/i/12345678" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": "not found"}`))
+			return
+		}
+		w.Write([]byte(`{"id": "12345678", "host": "1.2.3.4", "port": 12345, "type": "redis", "status": "running"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(testConfig)
+	client.url = ts.URL
+	instance, err := client.Get("12345678")
+	if err != nil {
+		t.Fatal("client.Get returned an error:", err)
+	}
+	if instance.ID != "12345678" || instance.Port != 12345 || instance.Status != "running" {
+		t.Errorf("client.Get returned wrong instance: %+v", instance)
+	}
+	instance, err = client.Get("87654321")
+	if !IsNotFound(err) {
+		t.Error("client.Get did not return a not found error:", err)
+	}
+	if instance != nil {
+		t.Error("client.Get returned an instance:", instance)
+	}
+}
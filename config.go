@@ -22,7 +22,10 @@ const MKtmpioCfgFile = "~/.mktmpio.yml"
 type Config struct {
 	Token string
 	URL   string `yaml:",omitempty"`
-	err   error
+	// Backend selects the Provisioner returned by NewProvisioner, either
+	// "mktmpio" (the default) or "local".
+	Backend string `yaml:",omitempty"`
+	err     error
 }
 
 func (c Config) String() string {
@@ -52,6 +55,7 @@ func EnvConfig() *Config {
 	config := new(Config)
 	config.Token = os.Getenv("MKTMPIO_TOKEN")
 	config.URL = os.Getenv("MKTMPIO_URL")
+	config.Backend = os.Getenv("MKTMPIO_BACKEND")
 	return config
 }
 
@@ -81,6 +85,11 @@ func (c *Config) Apply(b *Config) *Config {
 	} else {
 		newCfg.URL = b.URL
 	}
+	if b.Backend == "" {
+		newCfg.Backend = c.Backend
+	} else {
+		newCfg.Backend = b.Backend
+	}
 	return newCfg
 }
 
@@ -6,6 +6,7 @@ package mktmpio
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -16,9 +17,17 @@ func testEquivalent(t *testing.T, a *Config, b *Config) {
 	if a.URL != b.URL {
 		t.Error("URLs to not match", a.URL, b.URL)
 	}
+	if a.Backend != b.Backend {
+		t.Error("Backends to not match", a.Backend, b.Backend)
+	}
 }
 
 func TestConfigLoading(t *testing.T) {
+	if prev, ok := os.LookupEnv("MKTMPIO_TOKEN"); ok {
+		t.Cleanup(func() { os.Setenv("MKTMPIO_TOKEN", prev) })
+	} else {
+		t.Cleanup(func() { os.Unsetenv("MKTMPIO_TOKEN") })
+	}
 	if err := os.Setenv("MKTMPIO_TOKEN", "1234-5678-90abcdef"); err != nil {
 		t.Error("Could not set env var in test", err)
 	}
@@ -44,6 +53,8 @@ func TestConfigAppy(t *testing.T) {
 	a.URL = "AURL"
 	testEquivalent(t, &Config{Token: b.Token, URL: a.URL}, a.Apply(b))
 	testEquivalent(t, a, b.Apply(a))
+	b.Backend = BackendLocal
+	testEquivalent(t, &Config{Token: b.Token, URL: a.URL, Backend: BackendLocal}, a.Apply(b))
 }
 
 func TestConfigFile(t *testing.T) {
@@ -64,7 +75,7 @@ func TestConfigFile(t *testing.T) {
 }
 
 func TestConfigSave(t *testing.T) {
-	tmp := "temp_mktmpio.test.yml"
+	tmp := filepath.Join(t.TempDir(), "mktmpio.test.yml")
 	c := new(Config)
 	c.Save(tmp)
 	from := FileConfig(tmp)
@@ -0,0 +1,180 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// Dialer opens network connections. It is satisfied by *net.Dialer as well as
+// most proxy dialers.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// transport returns the *http.Transport used by the Client's http.Client, if
+// there is one.
+func (c Client) transport() *http.Transport {
+	rt := c.http().Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, _ := rt.(*http.Transport)
+	return t
+}
+
+// dialWS is websocket.DialConfig, but with the connection made using the
+// Client's Dialer, proxy and TLS settings and with the handshake bound to ctx.
+func (c Client) dialWS(ctx context.Context, cfg *websocket.Config) (*wsConn, error) {
+	tunnel, err := c.dialTunnel(ctx, cfg.Location)
+	if err != nil {
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	raw := &watchedConn{Conn: tunnel, timeout: c.keepalive.Timeout}
+	stop := closeOnDone(ctx, raw)
+	defer stop()
+	conn, err := websocket.NewClient(cfg, raw)
+	if err != nil {
+		raw.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	if raw.protocol() == "" {
+		conn.Config().Protocol = nil
+	}
+	ws := &wsConn{Conn: conn, raw: raw, done: make(chan struct{})}
+	if c.keepalive.Interval > 0 {
+		go ws.keepalive(c.keepalive.Interval)
+	}
+	return ws, nil
+}
+
+// dialTunnel opens a connection to the host of the given ws:// or wss:// URL,
+// going through the HTTP proxy configured for the equivalent http:// or
+// https:// URL and performing the TLS handshake as required.
+func (c Client) dialTunnel(ctx context.Context, location *url.URL) (net.Conn, error) {
+	secure := location.Scheme == "wss"
+	addr := location.Host
+	if location.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(location.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(location.Hostname(), "80")
+		}
+	}
+	var tlsCfg *tls.Config
+	var proxyURL *url.URL
+	if t := c.transport(); t != nil {
+		if t.TLSClientConfig != nil {
+			tlsCfg = t.TLSClientConfig.Clone()
+		}
+		if t.Proxy != nil {
+			target := *location
+			if secure {
+				target.Scheme = "https"
+			} else {
+				target.Scheme = "http"
+			}
+			req := &http.Request{Method: "GET", URL: &target, Header: http.Header{}}
+			var err error
+			if proxyURL, err = t.Proxy(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var conn net.Conn
+	var err error
+	if proxyURL != nil {
+		conn, err = c.dialProxy(ctx, proxyURL, addr, tlsCfg)
+	} else {
+		conn, err = c.dial().DialContext(ctx, "tcp", addr)
+	}
+	if err != nil || !secure {
+		return conn, err
+	}
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = location.Hostname()
+	}
+	return tls.Client(conn, tlsCfg), nil
+}
+
+// dialProxy opens a tunnel to addr through the HTTP or HTTPS proxy at
+// proxyURL using the CONNECT method. The TLS connection to an HTTPS proxy
+// uses tlsCfg, as http.Transport does. Other kinds of proxies, such as
+// SOCKS5, are not supported for websockets.
+func (c Client) dialProxy(ctx context.Context, proxyURL *url.URL, addr string, tlsCfg *tls.Config) (net.Conn, error) {
+	var defaultPort string
+	switch proxyURL.Scheme {
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme for websocket: %s", proxyURL.Scheme)
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), defaultPort)
+	}
+	conn, err := c.dial().DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if proxyURL.Scheme == "https" {
+		cfg := &tls.Config{}
+		if tlsCfg != nil {
+			cfg = tlsCfg.Clone()
+		}
+		cfg.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	return conn, nil
+}
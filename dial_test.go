@@ -0,0 +1,155 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type countingDialer struct {
+	net.Dialer
+	dialed []string
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+func echoServer() *httptest.Server {
+	return httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	ts := server(t, 200, `[]`)
+	defer ts.Close()
+	used := false
+	hc := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithHTTPClient(hc))
+	if _, err := client.List(); err != nil {
+		t.Error("client.List returned an error:", err)
+	}
+	if !used {
+		t.Error("custom http.Client was not used for API request")
+	}
+}
+
+func TestWithDialer(t *testing.T) {
+	ts := echoServer()
+	defer ts.Close()
+	d := &countingDialer{}
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithDialer(d))
+	rw, err := client.Attach("12345678")
+	if err != nil {
+		t.Fatal("Error attaching to echo server:", err)
+	}
+	defer rw.Close()
+	if len(d.dialed) != 1 || d.dialed[0] != ts.Listener.Addr().String() {
+		t.Error("custom Dialer was not used for websocket:", d.dialed)
+	}
+	api := server(t, 200, `[]`)
+	defer api.Close()
+	client, _ = NewClient(&Config{Token: "token", URL: api.URL}, WithDialer(d))
+	if _, err := client.List(); err != nil {
+		t.Error("client.List returned an error:", err)
+	}
+	if len(d.dialed) != 2 || d.dialed[1] != api.Listener.Addr().String() {
+		t.Error("custom Dialer was not used for API request:", d.dialed)
+	}
+}
+
+// connectProxy is an HTTP proxy that only supports CONNECT, recording the
+// address of the last tunnel in connected.
+func connectProxy(t *testing.T, connected *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			t.Error("proxy received non-CONNECT request:", r.Method)
+			return
+		}
+		*connected = r.Host
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, buf)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	})
+}
+
+func TestWebsocketProxy(t *testing.T) {
+	ts := echoServer()
+	defer ts.Close()
+	var connected string
+	proxy := httptest.NewServer(connectProxy(t, &connected))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	hc := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithHTTPClient(hc))
+	rw, err := client.Attach("12345678")
+	if err != nil {
+		t.Fatal("Error attaching through proxy:", err)
+	}
+	defer rw.Close()
+	if connected != ts.Listener.Addr().String() {
+		t.Error("websocket was not tunneled through proxy:", connected)
+	}
+	wsWriteT([]byte("ping\n"), rw, t)
+	line, err := bufio.NewReader(rw).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("unexpected echo through proxy: %q %v", line, err)
+	}
+}
+
+func TestWebsocketHTTPSProxy(t *testing.T) {
+	ts := echoServer()
+	defer ts.Close()
+	var connected string
+	proxy := httptest.NewTLSServer(connectProxy(t, &connected))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := proxy.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithHTTPClient(&http.Client{Transport: transport}))
+	rw, err := client.Attach("12345678")
+	if err != nil {
+		t.Fatal("Error attaching through HTTPS proxy:", err)
+	}
+	defer rw.Close()
+	if connected != ts.Listener.Addr().String() {
+		t.Error("websocket was not tunneled through HTTPS proxy:", connected)
+	}
+	transport.Proxy = http.ProxyURL(&url.URL{Scheme: "socks5", Host: proxyURL.Host})
+	if _, err := client.Attach("12345678"); err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Error("expected SOCKS5 proxy to be rejected for websockets, got", err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DumpOptions holds the settings for Instance.DumpWithOptions.
+type DumpOptions struct {
+	// Gzip compresses the dump with gzip as it is written.
+	Gzip bool
+}
+
+// dumpTools are the programs that dump the data of each service, run with
+// the connection arguments of the service's shell.
+var dumpTools = map[string]struct {
+	tool string   // replaces the shell
+	args []string // added to the shell's arguments
+}{
+	"postgres": {"pg_dump", nil},
+	"mysql":    {"mysqldump", []string{"--all-databases", "--single-transaction"}},
+	"mariadb":  {"mysqldump", []string{"--all-databases", "--single-transaction"}},
+	"mongodb":  {"mongodump", []string{"--archive"}},
+}
+
+// Dump writes a dump of the instance's data to w, produced by the service's
+// dump tool running in the instance's remote shell: pg_dump for postgres,
+// mysqldump for mysql, mongodump for mongodb and, for redis, the RDB file
+// written by SAVE. If the tool fails, the error is an *ExitError whose
+// Stderr holds the tool's error output.
+func (i *Instance) Dump(ctx context.Context, w io.Writer) error {
+	return i.DumpWithOptions(ctx, w, DumpOptions{})
+}
+
+// DumpWithOptions is like Dump but with the given options.
+func (i *Instance) DumpWithOptions(ctx context.Context, w io.Writer, opts DumpOptions) error {
+	cmd, err := i.dumpCmd()
+	if err != nil {
+		return err
+	}
+	e, err := i.execer()
+	if err != nil {
+		return err
+	}
+	out := w
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		out = zw
+	}
+	var stderr bytes.Buffer
+	err = e.exec(ctx, i.ID, cmd, nil, out, &stderr)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	return err
+}
+
+// dumpCmd returns the command that writes a dump of the instance to stdout.
+func (i *Instance) dumpCmd() ([]string, error) {
+	if i.Type == "redis" {
+		return i.redisDumpCmd(), nil
+	}
+	dump, ok := dumpTools[i.Type]
+	if !ok {
+		return nil, fmt.Errorf("dumping %s instances is not supported", i.Type)
+	}
+	cmd := []string{dump.tool}
+	if len(i.ContainerShell) > 0 {
+		cmd = append(cmd, i.ContainerShell[1:]...)
+	}
+	return append(cmd, dump.args...), nil
+}
+
+// redisDumpCmd returns a shell script that has redis SAVE a snapshot and
+// then copies the RDB file it wrote to stdout. redis-cli exits successfully
+// when redis replies with an error, so the reply to SAVE is checked.
+func (i *Instance) redisDumpCmd() []string {
+	redis := i.redisCli()
+	script := fmt.Sprintf(`reply=$(%[1]s SAVE)
+if [ "$reply" != OK ]; then echo "redis SAVE: $reply" >&2; exit 1; fi
+dir=$(%[1]s --raw CONFIG GET dir | tail -n 1) &&
+file=$(%[1]s --raw CONFIG GET dbfilename | tail -n 1) &&
+cat "$dir/$file"`, redis)
+	return []string{"sh", "-c", script}
+}
+
+// redisCli returns the instance's shell quoted for use in shell scripts.
+func (i *Instance) redisCli() string {
+	cli := i.ContainerShell
+	if len(cli) == 0 {
+		cli = []string{"redis-cli"}
+	}
+	quoted := make([]string, len(cli))
+	for n, arg := range cli {
+		quoted[n] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell script.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// restoreTools are the programs that restore dumps of each service, run
+// with the connection arguments of the service's shell. Postgres and mysql
+// dumps are SQL scripts, so they are loaded like Seed does.
+var restoreTools = map[string]struct {
+	tool string   // replaces the shell
+	args []string // added to the shell's arguments
+}{
+	"mongodb": {"mongorestore", []string{"--archive"}},
+}
+
+// CreateFromDump creates an instance of `service`, waits for it to be ready
+// and restores into it the dump read from r, as written by Instance.Dump.
+// If restoring fails, the instance is destroyed and the error is returned.
+func (c Client) CreateFromDump(ctx context.Context, service string, r io.Reader) (*Instance, error) {
+	return c.CreateFromDumpWithOptions(ctx, service, r, CreateOptions{})
+}
+
+// CreateFromDumpWithOptions is like CreateFromDump but creates the instance
+// with the given options.
+func (c Client) CreateFromDumpWithOptions(ctx context.Context, service string, r io.Reader, opts CreateOptions) (*Instance, error) {
+	if _, err := (&Instance{Type: service}).restoreCmd(); err != nil {
+		return nil, err
+	}
+	instance, err := c.CreateWithOptionsContext(ctx, service, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = instance.WaitReady(ctx)
+	if err == nil {
+		err = instance.Restore(ctx, r)
+	}
+	if err != nil {
+		// ctx may be done, but the instance should still go
+		if destroyErr := c.DestroyContext(context.Background(), instance.ID); destroyErr != nil {
+			c.log().Printf("error destroying instance %s after failed restore: %s", instance.ID, destroyErr)
+		}
+		return nil, err
+	}
+	return instance, nil
+}
+
+// Restore loads the dump read from r, as written by Dump, into the instance
+// using the service's restore tool running in the instance's remote shell.
+// Gzipped dumps are decompressed. If the tool fails, the error is a
+// *SeedError. Redis dumps are loaded with DEBUG RELOAD, which redis 7 and
+// later only allow if the enable-debug-command option is set.
+func (i *Instance) Restore(ctx context.Context, r io.Reader) error {
+	cmd, err := i.restoreCmd()
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	var dump io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		dump = zr
+	}
+	return i.seed(ctx, "dump", cmd, dump).Err
+}
+
+// restoreCmd returns the command that restores a dump of the instance read
+// from stdin.
+func (i *Instance) restoreCmd() ([]string, error) {
+	switch i.Type {
+	case "postgres", "mysql", "mariadb":
+		return i.seedCmd(), nil
+	case "redis":
+		return i.redisRestoreCmd(), nil
+	}
+	restore, ok := restoreTools[i.Type]
+	if !ok {
+		return nil, fmt.Errorf("restoring %s instances is not supported", i.Type)
+	}
+	cmd := []string{restore.tool}
+	if len(i.ContainerShell) > 0 {
+		cmd = append(cmd, i.ContainerShell[1:]...)
+	}
+	return append(cmd, restore.args...), nil
+}
+
+// redisRestoreCmd returns a shell script that replaces the RDB file of redis
+// with stdin and has redis load it with DEBUG RELOAD NOSAVE, which does not
+// save its current data first. That requires redis 6.2 or later and, since
+// redis 7, the enable-debug-command option, so the script checks that DEBUG
+// is allowed before touching the RDB file.
+func (i *Instance) redisRestoreCmd() []string {
+	redis := i.redisCli()
+	script := fmt.Sprintf(`set -e
+help=$(%[1]s DEBUG HELP)
+case "$help" in
+*RELOAD*) ;;
+*) echo "$help" >&2
+   echo "restoring redis dumps requires DEBUG RELOAD, see enable-debug-command" >&2
+   exit 1 ;;
+esac
+dir=$(%[1]s --raw CONFIG GET dir | tail -n 1)
+file=$(%[1]s --raw CONFIG GET dbfilename | tail -n 1)
+cat > "$dir/$file.restore"
+mv "$dir/$file.restore" "$dir/$file"
+reply=$(%[1]s DEBUG RELOAD NOSAVE)
+if [ "$reply" != OK ]; then echo "$reply" >&2; exit 1; fi`, redis)
+	return []string{"sh", "-c", script}
+}
@@ -0,0 +1,260 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+// dumpShell writes the command it is run with to stdout, unless it or stdin
+// includes "fail", which makes it exit with status 1.
+func dumpShell(run shellRun, stdout, stderr io.Writer) int {
+	cmd := strings.Join(run.args, " ")
+	if strings.Contains(cmd, "fail") || strings.Contains(run.stdin, "fail") {
+		io.WriteString(stderr, "pg_dump: error: connection failed")
+		return 1
+	}
+	io.WriteString(stdout, cmd)
+	return 0
+}
+
+// dumpInstance creates an instance of the given type with the given
+// container shell.
+func dumpInstance(t *testing.T, client *mktmpio.Client, typ string, shell []string) *mktmpio.Instance {
+	instance, err := client.Create(typ)
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	instance.ContainerShell = shell
+	return instance
+}
+
+func TestDump(t *testing.T) {
+	s, _ := shellServer(t, dumpShell)
+	client := s.Client()
+	tests := []struct {
+		typ   string
+		shell []string
+		cmd   string
+	}{
+		{"postgres", []string{"psql", "-U", "mktmpio"}, "pg_dump -U mktmpio"},
+		{"mysql", []string{"mysql", "-u", "root"}, "mysqldump -u root --all-databases --single-transaction"},
+		{"mongodb", nil, "mongodump --archive"},
+		{"redis", []string{"redis-cli", "-a", "it's"}, "sh -c reply=$('redis-cli' '-a' 'it'\\''s' SAVE)"},
+	}
+	for _, tt := range tests {
+		instance := dumpInstance(t, client, tt.typ, tt.shell)
+		var out bytes.Buffer
+		if err := instance.Dump(context.Background(), &out); err != nil {
+			t.Errorf("%s: Dump returned an error: %v", tt.typ, err)
+		}
+		if !strings.HasPrefix(out.String(), tt.cmd) {
+			t.Errorf("%s: expected %q, got %q", tt.typ, tt.cmd, out.String())
+		}
+	}
+}
+
+// redisShell runs commands with sh, using a fake redis-cli that replies to
+// SAVE with saveReply, allows DEBUG if debug is set and replies to CONFIG
+// GET with a temporary directory holding the RDB file. It returns the path
+// of the RDB file.
+func redisShell(t *testing.T, saveReply string, debug bool) (func(shellRun, io.Writer, io.Writer) int, string) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh to be installed")
+	}
+	dir := t.TempDir()
+	debugHelp, debugReload := "RELOAD [option value ...]", "OK"
+	if !debug {
+		debugHelp = "ERR DEBUG command not allowed. If the enable-debug-command option is set to \"no\", you can't use this command."
+		debugReload = debugHelp
+	}
+	cli := fmt.Sprintf(`#!/bin/sh
+case "$*" in
+*"CONFIG GET dir") printf 'dir\n%%s\n' %[1]s ;;
+*"CONFIG GET dbfilename") printf 'dbfilename\ndump.rdb\n' ;;
+*"DEBUG HELP") echo %[3]s ;;
+*"DEBUG RELOAD NOSAVE") echo %[4]s ;;
+*SAVE) echo %[2]s ;;
+*) echo "ERR unknown command" ;;
+esac
+`, strconv.Quote(dir), strconv.Quote(saveReply), strconv.Quote(debugHelp), strconv.Quote(debugReload))
+	if err := ioutil.WriteFile(filepath.Join(dir, "redis-cli"), []byte(cli), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return func(run shellRun, stdout, stderr io.Writer) int {
+		cmd := exec.Command(run.args[0], run.args[1:]...)
+		cmd.Env = append(os.Environ(), "PATH="+dir+string(filepath.ListSeparator)+os.Getenv("PATH"))
+		cmd.Stdin = strings.NewReader(run.stdin)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return exitErr.ExitCode()
+			}
+			fmt.Fprintln(stderr, err)
+			return 127
+		}
+		return 0
+	}, filepath.Join(dir, "dump.rdb")
+}
+
+func TestDumpRedis(t *testing.T) {
+	shell, rdb := redisShell(t, "OK", true)
+	ioutil.WriteFile(rdb, []byte("REDIS0009"), 0644)
+	s, _ := shellServer(t, shell)
+	instance := dumpInstance(t, s.Client(), "redis", nil)
+	var out bytes.Buffer
+	if err := instance.Dump(context.Background(), &out); err != nil || out.String() != "REDIS0009" {
+		t.Errorf("wrong dump: %q %v", out.String(), err)
+	}
+
+	shell, rdb = redisShell(t, "ERR Background save already in progress", true)
+	ioutil.WriteFile(rdb, []byte("REDIS0009"), 0644)
+	s, _ = shellServer(t, shell)
+	instance = dumpInstance(t, s.Client(), "redis", nil)
+	out.Reset()
+	err := instance.Dump(context.Background(), &out)
+	var exitErr *mktmpio.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(string(exitErr.Stderr), "Background save already in progress") {
+		t.Errorf("expected ExitError for the failed SAVE, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("stale dump written after the SAVE failed: %q", out.String())
+	}
+}
+
+func TestDumpGzip(t *testing.T) {
+	s, _ := shellServer(t, dumpShell)
+	client := s.Client()
+	instance := dumpInstance(t, client, "postgres", []string{"psql"})
+	var out bytes.Buffer
+	if err := instance.DumpWithOptions(context.Background(), &out, mktmpio.DumpOptions{Gzip: true}); err != nil {
+		t.Fatal("DumpWithOptions returned an error:", err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal("dump is not gzipped:", err)
+	}
+	if data, err := ioutil.ReadAll(zr); err != nil || string(data) != "pg_dump" {
+		t.Errorf("wrong dump: %q %v", data, err)
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	s, _ := shellServer(t, dumpShell)
+	client := s.Client()
+	instance := dumpInstance(t, client, "postgres", []string{"psql", "fail"})
+	err := instance.Dump(context.Background(), ioutil.Discard)
+	var exitErr *mktmpio.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 || !strings.Contains(string(exitErr.Stderr), "connection failed") {
+		t.Errorf("expected ExitError with stderr, got %v", err)
+	}
+	instance.Type = "memcached"
+	if err := instance.Dump(context.Background(), ioutil.Discard); err == nil {
+		t.Error("Dump of an unsupported service did not fail")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s, log := shellServer(t, dumpShell)
+	client := s.Client()
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("CREATE TABLE t ();"))
+	zw.Close()
+	tests := []struct {
+		typ   string
+		shell []string
+		dump  []byte
+		cmd   string
+	}{
+		{"postgres", []string{"psql", "-U", "mktmpio"}, []byte("CREATE TABLE t ();"), "psql -U mktmpio -v ON_ERROR_STOP=1"},
+		{"postgres", []string{"psql"}, gzipped.Bytes(), "psql -v ON_ERROR_STOP=1"},
+		{"mysql", []string{"mysql", "-u", "root"}, []byte("CREATE TABLE t ();"), "mysql -u root"},
+		{"mongodb", []string{"mongo", "--quiet"}, []byte("CREATE TABLE t ();"), "mongorestore --quiet --archive"},
+		{"redis", nil, []byte("CREATE TABLE t ();"), "sh -c set -e\nhelp=$('redis-cli' DEBUG HELP)"},
+	}
+	for _, tt := range tests {
+		instance := dumpInstance(t, client, tt.typ, tt.shell)
+		if err := instance.Restore(context.Background(), bytes.NewReader(tt.dump)); err != nil {
+			t.Errorf("%s: Restore returned an error: %v", tt.typ, err)
+		}
+		run := log.last()
+		if cmd := strings.Join(run.args, " "); !strings.HasPrefix(cmd, tt.cmd) {
+			t.Errorf("%s: expected %q, got %q", tt.typ, tt.cmd, cmd)
+		}
+		if run.stdin != "CREATE TABLE t ();" {
+			t.Errorf("%s: wrong dump restored: %q", tt.typ, run.stdin)
+		}
+	}
+	instance := dumpInstance(t, client, "memcached", nil)
+	if err := instance.Restore(context.Background(), strings.NewReader("")); err == nil {
+		t.Error("Restore of an unsupported service did not fail")
+	}
+}
+
+func TestRestoreRedis(t *testing.T) {
+	shell, rdb := redisShell(t, "OK", true)
+	s, _ := shellServer(t, shell)
+	instance := dumpInstance(t, s.Client(), "redis", nil)
+	if err := instance.Restore(context.Background(), strings.NewReader("REDIS0009")); err != nil {
+		t.Fatal("Restore returned an error:", err)
+	}
+	if data, _ := ioutil.ReadFile(rdb); string(data) != "REDIS0009" {
+		t.Errorf("wrong RDB file restored: %q", data)
+	}
+
+	shell, rdb = redisShell(t, "OK", false)
+	s, _ = shellServer(t, shell)
+	instance = dumpInstance(t, s.Client(), "redis", nil)
+	err := instance.Restore(context.Background(), strings.NewReader("REDIS0009"))
+	var seedErr *mktmpio.SeedError
+	if !errors.As(err, &seedErr) || !strings.HasPrefix(seedErr.Message, "ERR DEBUG command not allowed") {
+		t.Errorf("expected SeedError for the disabled DEBUG command, got %v", err)
+	}
+	if _, err := os.Stat(rdb); !os.IsNotExist(err) {
+		t.Error("RDB file written although DEBUG is not allowed")
+	}
+}
+
+func TestCreateFromDump(t *testing.T) {
+	s, log := shellServer(t, dumpShell)
+	client := s.Client()
+	instance, err := client.CreateFromDump(context.Background(), "postgres", strings.NewReader("CREATE TABLE t ();"))
+	if err != nil {
+		t.Fatal("CreateFromDump returned an error:", err)
+	}
+	if stdin := log.last().stdin; stdin != "CREATE TABLE t ();" {
+		t.Errorf("wrong dump restored: %q", stdin)
+	}
+	instance.Destroy()
+	_, err = client.CreateFromDump(context.Background(), "postgres", strings.NewReader("fail"))
+	var seedErr *mktmpio.SeedError
+	if !errors.As(err, &seedErr) || seedErr.ExitCode != 1 {
+		t.Fatalf("expected SeedError, got %v", err)
+	}
+	if n := len(s.Instances()); n != 0 {
+		t.Errorf("instance not destroyed after failed restore, %d left", n)
+	}
+	if _, err := client.CreateFromDump(context.Background(), "memcached", strings.NewReader("")); err == nil {
+		t.Error("CreateFromDump of an unsupported service did not fail")
+	}
+	if n := s.RequestCount("POST", "/new/"); n != 2 {
+		t.Error("wrong number of instances created:", n)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned when the mktmpio API responds to a request with a
+// non-successful HTTP status.
+type APIError struct {
+	StatusCode int    // HTTP status code of the response
+	Code       string // machine readable error code, if the server sent one
+	Message    string // human readable error message from the server
+	Method     string // HTTP method of the failed request
+	Path       string // API path of the failed request
+	RequestID  string // value of the X-Request-Id response header, if any
+
+	// RetryAfter is how long the server asked the client to wait before
+	// trying again, as given by the Retry-After response header.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	s := fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, msg)
+	if e.RequestID != "" {
+		s += " (request " + e.RequestID + ")"
+	}
+	return s
+}
+
+// ErrConnectionLost is returned when a websocket session ends without the
+// server closing it, such as when the network connection drops.
+var ErrConnectionLost = errors.New("connection lost")
+
+// ErrIdleTimeout is returned when the server stops responding on a websocket
+// session for longer than the Client's KeepalivePolicy allows.
+var ErrIdleTimeout = errors.New("websocket peer stopped responding")
+
+// CloseError is returned when the server closes a websocket session with a
+// status other than a normal closure.
+type CloseError struct {
+	Code   int    // websocket close status code
+	Reason string // reason sent along with the status, if any
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with status %d: %s", e.Code, e.Reason)
+}
+
+// newAPIError creates an APIError describing the given failed response.
+func newAPIError(req *http.Request, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+	}
+	var payload struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		apiErr.Message = payload.Error
+		apiErr.Code = payload.Code
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+func apiErrorStatus(err error) (int, string) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode, apiErr.Code
+	}
+	return 0, ""
+}
+
+// IsNotFound reports whether err is an APIError caused by the requested
+// resource not existing, such as an instance that has already been destroyed.
+func IsNotFound(err error) bool {
+	status, _ := apiErrorStatus(err)
+	return status == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether err is an APIError caused by a missing or
+// invalid token.
+func IsUnauthorized(err error) bool {
+	status, _ := apiErrorStatus(err)
+	return status == http.StatusUnauthorized
+}
+
+// IsQuotaExceeded reports whether err is an APIError caused by the account
+// having reached its limit of instances.
+func IsQuotaExceeded(err error) bool {
+	status, code := apiErrorStatus(err)
+	return status == http.StatusPaymentRequired || code == "quota_exceeded"
+}
+
+// IsRateLimited reports whether err is an APIError caused by sending too many
+// requests to the API.
+func IsRateLimited(err error) bool {
+	status, _ := apiErrorStatus(err)
+	return status == http.StatusTooManyRequests
+}
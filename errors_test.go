@@ -0,0 +1,85 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req-1234")
+		w.WriteHeader(402)
+		w.Write([]byte(`{"error": "instance limit reached", "code": "quota_exceeded"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	_, err := client.Create("redis")
+	var apiErr *APIError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &apiErr) {
+		t.Fatal("client.Create did not return an APIError:", err)
+	}
+	if apiErr.StatusCode != 402 {
+		t.Error("wrong status code:", apiErr.StatusCode)
+	}
+	if apiErr.Code != "quota_exceeded" {
+		t.Error("wrong error code:", apiErr.Code)
+	}
+	if apiErr.Message != "instance limit reached" {
+		t.Error("wrong message:", apiErr.Message)
+	}
+	if apiErr.Method != "POST" || apiErr.Path != "/new/redis" {
+		t.Error("wrong request recorded:", apiErr.Method, apiErr.Path)
+	}
+	if apiErr.RequestID != "req-1234" {
+		t.Error("wrong request ID:", apiErr.RequestID)
+	}
+	if !IsQuotaExceeded(err) {
+		t.Error("IsQuotaExceeded should be true for", err)
+	}
+	if IsNotFound(err) || IsUnauthorized(err) || IsRateLimited(err) {
+		t.Error("only IsQuotaExceeded should be true for", err)
+	}
+}
+
+func TestAPIErrorStatuses(t *testing.T) {
+	cases := []struct {
+		status int
+		check  func(error) bool
+	}{
+		{404, IsNotFound},
+		{401, IsUnauthorized},
+		{402, IsQuotaExceeded},
+		{429, IsRateLimited},
+	}
+	for _, c := range cases {
+		err := &APIError{StatusCode: c.status}
+		if !c.check(err) {
+			t.Errorf("status %d not detected: %s", c.status, err)
+		}
+	}
+	if IsNotFound(errors.New("not found")) {
+		t.Error("IsNotFound should only match APIErrors")
+	}
+}
+
+func TestAPIErrorShortBody(t *testing.T) {
+	ts := server(t, 500, `x`)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	_, err := client.List()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatal("client.List did not return an APIError:", err)
+	}
+	if apiErr.Message != "x" {
+		t.Error("raw body not used as message:", apiErr.Message)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"sync"
+
+	"github.com/mktmpio/go-mktmpio/stdcopy"
+)
+
+// ErrNoExitStatus is returned when a remote command's output ended without
+// the server reporting the command's exit status, which happens if the
+// connection is lost or the server predates the mktmpio.stdio.v2 protocol.
+var ErrNoExitStatus = errors.New("remote command ended without exit status")
+
+// ExitError is returned when a remote command exits with a non-zero status.
+type ExitError struct {
+	ExitCode int
+	// Stderr holds the command's standard error output if it was not
+	// otherwise collected, as with os/exec's Cmd.Output.
+	Stderr []byte
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.ExitCode)
+}
+
+// ExecResult holds the output and exit status of a remote command run by
+// Exec.
+type ExecResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Cmd is a command to be run in the remote shell of an instance. It mirrors
+// os/exec's Cmd: set Stdin, Stdout and Stderr as required, then call Run,
+// Output or CombinedOutput.
+type Cmd struct {
+	// Args holds the command and its arguments. If empty, the instance's
+	// default shell is run, as with AttachStdio.
+	Args []string
+	// Stdin is the command's standard input. If nil, the command receives
+	// an immediate EOF. Otherwise, as with os/exec, Run does not return
+	// until copying Stdin has stopped, which happens once reading it fails
+	// or returns EOF, or writing it fails after the command has exited.
+	Stdin io.Reader
+	// Stdout and Stderr receive the command's output. If nil, the output is
+	// discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	ctx      context.Context
+	client   Client
+	id       string
+	started  bool
+	exitCode int
+}
+
+// Command returns a Cmd that runs the given command in the remote shell of
+// the instance identified by `id`. The command is aborted if ctx is done
+// before it completes.
+func (c Client) Command(ctx context.Context, id string, args ...string) *Cmd {
+	return &Cmd{Args: args, ctx: ctx, client: c, id: id, exitCode: -1}
+}
+
+// Exec runs the command `cmd` in the remote shell of the instance identified
+// by `id`, feeding it stdin, and returns its output and exit status. If the
+// command exits with a non-zero status, the result is returned along with an
+// *ExitError. If the server does not report the exit status, the result is
+// returned with an ExitCode of -1 along with ErrNoExitStatus.
+func (c Client) Exec(ctx context.Context, id string, cmd []string, stdin io.Reader) (*ExecResult, error) {
+	return execResult(ctx, c, id, cmd, stdin)
+}
+
+// Run runs the command and waits for it to complete. If the command exits
+// with a non-zero status, the error is an *ExitError.
+func (c *Cmd) Run() error {
+	if c.started {
+		return errors.New("mktmpio: Cmd already run")
+	}
+	c.started = true
+	params := url.Values{}
+	params.Set("id", c.id)
+	params.Set("stdio", "true")
+	for _, arg := range c.Args {
+		params.Add("cmd", arg)
+	}
+	conn, err := c.client.openWS(c.ctx, params)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := closeOnDone(c.ctx, conn)
+	defer stop()
+	stdinDone := make(chan struct{})
+	go func() {
+		defer close(stdinDone)
+		stdin := newStdinWriter(conn.Conn)
+		if c.Stdin != nil {
+			if _, err := io.Copy(stdin, c.Stdin); err != nil {
+				return
+			}
+		}
+		stdin.CloseStdin()
+	}()
+	// closing conn makes any further writes of stdin fail
+	defer func() {
+		conn.Close()
+		<-stdinDone
+	}()
+	stdout, stderr := c.Stdout, c.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	_, status, err := stdcopy.StdCopyExit(stdout, stderr, conn)
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if status == stdcopy.NoExitStatus {
+		if err := conn.closeErr(); err != nil {
+			return err
+		}
+		return ErrNoExitStatus
+	}
+	c.exitCode = status
+	if status != 0 {
+		return &ExitError{ExitCode: status}
+	}
+	return nil
+}
+
+// Output runs the command and returns its standard output. If Stderr was not
+// set and the command fails, its standard error is available as the Stderr
+// field of the returned *ExitError.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("mktmpio: Stdout already set")
+	}
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	captureErr := c.Stderr == nil
+	if captureErr {
+		c.Stderr = &stderr
+	}
+	err := c.Run()
+	var exitErr *ExitError
+	if captureErr && errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its standard output and
+// standard error combined.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil || c.Stderr != nil {
+		return nil, errors.New("mktmpio: Stdout or Stderr already set")
+	}
+	var b lockedBuffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// ExitCode returns the exit status of the command, or -1 if it has not
+// completed.
+func (c *Cmd) ExitCode() int {
+	return c.exitCode
+}
+
+// execer is implemented by the Provisioners that support Exec. exec runs a
+// command, streaming its output to stdout and stderr.
+type execer interface {
+	exec(ctx context.Context, id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+func (c Client) exec(ctx context.Context, id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	command := c.Command(ctx, id, cmd...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	return command.Run()
+}
+
+// execResult runs a command with e, collecting its output and exit status.
+func execResult(ctx context.Context, e execer, id string, cmd []string, stdin io.Reader) (*ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	err := e.exec(ctx, id, cmd, stdin, &stdout, &stderr)
+	result := &ExecResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode
+	case err == ErrNoExitStatus:
+		result.ExitCode = -1
+	default:
+		return nil, err
+	}
+	return result, err
+}
+
+// execer returns the instance's Provisioner if it supports Exec.
+func (i *Instance) execer() (execer, error) {
+	e, ok := i.provisioner().(execer)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support Exec", i.client)
+	}
+	return e, nil
+}
+
+// Exec runs the command `cmd` in the instance's remote shell. See
+// Client.Exec for details.
+func (i *Instance) Exec(ctx context.Context, cmd []string, stdin io.Reader) (*ExecResult, error) {
+	e, err := i.execer()
+	if err != nil {
+		return nil, err
+	}
+	return execResult(ctx, e, i.ID, cmd, stdin)
+}
+
+// Exec runs the command `cmd` locally, or the instance's command line client
+// if cmd is empty, returning its output and exit status.
+func (l *Local) Exec(ctx context.Context, id string, cmd []string, stdin io.Reader) (*ExecResult, error) {
+	return execResult(ctx, l, id, cmd, stdin)
+}
+
+func (l *Local) exec(ctx context.Context, id string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	inst, err := l.lookup("GET", id)
+	if err != nil {
+		return err
+	}
+	if len(cmd) == 0 {
+		cmd = inst.ContainerShell
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("instance %s has no command line client to run", id)
+	}
+	command := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	err = command.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &ExitError{ExitCode: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// lockedBuffer is a bytes.Buffer that is safe to write to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
@@ -0,0 +1,134 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio"
+	"github.com/mktmpio/go-mktmpio/mktmpiotest"
+)
+
+// execServer starts a Server whose shell echoes stdin upper-cased to stdout
+// and the requested command to stderr, exiting with `status`. A negative
+// status makes the Server use the legacy stdio protocol, which has no exit
+// statuses. It returns a client and the ID of an instance on the Server.
+func execServer(t *testing.T, status int) (*mktmpio.Client, string) {
+	s, _ := shellServer(t, func(run shellRun, stdout, stderr io.Writer) int {
+		io.WriteString(stdout, strings.ToUpper(run.stdin))
+		io.WriteString(stderr, strings.Join(run.args, " "))
+		return status
+	})
+	s.LegacyStdio = status < 0
+	client := s.Client()
+	instance, err := client.Create("redis")
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	return client, instance.ID
+}
+
+func TestExec(t *testing.T) {
+	client, id := execServer(t, 0)
+	res, err := client.Exec(context.Background(), id, []string{"tr", "a-z", "A-Z"}, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal("Exec returned an error:", err)
+	}
+	if string(res.Stdout) != "HELLO" || string(res.Stderr) != "tr a-z A-Z" || res.ExitCode != 0 {
+		t.Errorf("wrong result: %q %q %d", res.Stdout, res.Stderr, res.ExitCode)
+	}
+}
+
+func TestExecExitStatus(t *testing.T) {
+	client, id := execServer(t, 2)
+	res, err := client.Exec(context.Background(), id, []string{"false"}, nil)
+	var exitErr *mktmpio.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 2 {
+		t.Fatalf("expected exit status 2, got %v", err)
+	}
+	if res == nil || res.ExitCode != 2 || string(res.Stderr) != "false" {
+		t.Errorf("wrong result: %+v", res)
+	}
+	cmd := client.Command(context.Background(), id, "false")
+	if _, err := cmd.Output(); !errors.As(err, &exitErr) || string(exitErr.Stderr) != "false" {
+		t.Errorf("Output did not capture stderr: %v", err)
+	}
+	if cmd.ExitCode() != 2 {
+		t.Errorf("wrong exit code: %d", cmd.ExitCode())
+	}
+}
+
+func TestExecNoExitStatus(t *testing.T) {
+	client, id := execServer(t, -1)
+	cmd := client.Command(context.Background(), id, "echo")
+	cmd.Stdin = strings.NewReader("hi")
+	cmd.Stdout = ioutil.Discard
+	if err := cmd.Run(); err != mktmpio.ErrNoExitStatus {
+		t.Errorf("expected ErrNoExitStatus, got %v", err)
+	}
+	if cmd.ExitCode() != -1 {
+		t.Errorf("wrong exit code: %d", cmd.ExitCode())
+	}
+	if err := cmd.Run(); err == nil || err == mktmpio.ErrNoExitStatus {
+		t.Error("Cmd ran twice:", err)
+	}
+	res, err := client.Exec(context.Background(), id, nil, strings.NewReader("hi"))
+	if err != mktmpio.ErrNoExitStatus || res == nil || res.ExitCode != -1 || string(res.Stdout) != "HI" {
+		t.Errorf("output not returned without exit status: %+v %v", res, err)
+	}
+}
+
+func TestCmdCombinedOutput(t *testing.T) {
+	client, id := execServer(t, 0)
+	cmd := client.Command(context.Background(), id, "cat")
+	cmd.Stdin = strings.NewReader("hi ")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "HI cat" {
+		t.Errorf("wrong combined output: %q %v", out, err)
+	}
+	if err := cmd.Run(); err == nil {
+		t.Error("Cmd ran twice")
+	}
+}
+
+// endlessReader counts the reads of a never-ending input.
+type endlessReader struct {
+	reads int32
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return len(p), nil
+}
+
+func TestCmdWaitsForStdin(t *testing.T) {
+	s := mktmpiotest.NewServer()
+	t.Cleanup(s.Close)
+	// exit without reading stdin
+	s.Shell = func(sh *mktmpiotest.Shell) int { return 0 }
+	client := s.Client()
+	instance, err := client.Create("redis")
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	stdin := &endlessReader{}
+	cmd := client.Command(context.Background(), instance.ID, "true")
+	cmd.Stdin = stdin
+	if err := cmd.Run(); err != nil {
+		t.Fatal("Run returned an error:", err)
+	}
+	reads := atomic.LoadInt32(&stdin.reads)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&stdin.reads); n != reads {
+		t.Errorf("stdin still read after Run returned: %d more reads", n-reads)
+	}
+}
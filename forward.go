@@ -0,0 +1,113 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// PortForwarder accepts TCP connections on a local address and tunnels each
+// of them to an instance's database port over a websocket, for networks that
+// allow HTTPS but block direct connections to the instance.
+type PortForwarder struct {
+	listener net.Listener
+	client   Client
+	id       string
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	err      error
+}
+
+// PortForward starts forwarding connections made to localAddr, such as
+// "127.0.0.1:5432" or "127.0.0.1:0" for any free port, to the database port
+// of the instance identified by `id`. Forwarding continues until ctx is done
+// or the PortForwarder is closed.
+func (c Client) PortForward(ctx context.Context, id, localAddr string) (*PortForwarder, error) {
+	l, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	f := &PortForwarder{listener: l, client: c, id: id, cancel: cancel}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	f.wg.Add(1)
+	go f.serve(ctx)
+	return f, nil
+}
+
+// Addr returns the local address that connections are forwarded from.
+func (f *PortForwarder) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Close stops accepting connections, closes all forwarded connections and
+// waits for them to finish.
+func (f *PortForwarder) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return nil
+}
+
+// Err returns the error, if any, that caused the most recent forwarded
+// connection to fail to open its tunnel.
+func (f *PortForwarder) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *PortForwarder) serve(ctx context.Context) {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.forward(ctx, conn)
+		}()
+	}
+}
+
+// forward tunnels a single local connection to the instance.
+func (f *PortForwarder) forward(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	params := url.Values{}
+	params.Set("id", f.id)
+	params.Set("forward", "true")
+	ws, err := f.client.openWS(ctx, params)
+	if err != nil {
+		f.client.log().Printf("error forwarding connection from %s: %s", conn.RemoteAddr(), err)
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+		return
+	}
+	defer ws.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(ws, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, ws)
+		done <- struct{}{}
+	}()
+	// websockets have no half-close, so the tunnel ends as soon as either
+	// side is done
+	<-done
+}
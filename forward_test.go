@@ -0,0 +1,64 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestPortForward(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		query = ws.Request().URL.RawQuery
+		ws.PayloadType = websocket.BinaryFrame
+		io.Copy(ws, ws)
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	f, err := client.PortForward(context.Background(), "12345678", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("PortForward returned an error:", err)
+	}
+	defer f.Close()
+	for n := 0; n < 2; n++ {
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatal("Could not connect to forwarded port:", err)
+		}
+		conn.Write([]byte("PING\r\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "PING\r\n" {
+			t.Errorf("unexpected reply through tunnel: %q %v", line, err)
+		}
+		conn.Close()
+	}
+	if query != "forward=true&id=12345678" {
+		t.Error("wrong websocket query:", query)
+	}
+}
+
+func TestPortForwardCanceled(t *testing.T) {
+	ts := echoServer()
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := client.PortForward(ctx, "12345678", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("PortForward returned an error:", err)
+	}
+	cancel()
+	f.Close()
+	if conn, err := net.Dial("tcp", f.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("forwarded port still accepting connections after cancel")
+	}
+}
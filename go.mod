@@ -1,5 +1,7 @@
 module github.com/mktmpio/go-mktmpio
 
+go 1.15
+
 require (
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mitchellh/go-homedir v0.0.0-20160621174243-756f7b183b7a
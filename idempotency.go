@@ -0,0 +1,23 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// NewIdempotencyKey generates a random key suitable for passing to
+// Client.CreateWithKey. Callers that want to be able to recover an instance
+// after a crash should persist the key before calling CreateWithKey.
+func NewIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("mktmpio: unable to generate idempotency key: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
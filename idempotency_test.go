@@ -0,0 +1,90 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewIdempotencyKey(t *testing.T) {
+	a, b := NewIdempotencyKey(), NewIdempotencyKey()
+	if len(a) != 32 {
+		t.Error("unexpected key length:", a)
+	}
+	if a == b {
+		t.Error("keys should be unique:", a, b)
+	}
+}
+
+func TestCreateRetriesWithSameKey(t *testing.T) {
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(502)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		w.Write([]byte(`{"id": "12345678", "type": "redis"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(fastRetries))
+	instance, err := client.CreateWithKey("redis", "my-key")
+	if err != nil {
+		t.Fatal("client.CreateWithKey was not retried:", err)
+	}
+	if len(keys) != 2 || keys[0] != "my-key" || keys[1] != "my-key" {
+		t.Error("idempotency key not sent consistently:", keys)
+	}
+	if instance.IdempotencyKey != "my-key" {
+		t.Error("instance does not record its idempotency key:", instance.IdempotencyKey)
+	}
+}
+
+func TestCreateGeneratesKey(t *testing.T) {
+	var key string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		w.Write([]byte(`{"id": "12345678", "type": "redis"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance, err := client.Create("redis")
+	if err != nil {
+		t.Fatal("client.Create returned an error:", err)
+	}
+	if key == "" || instance.IdempotencyKey != key {
+		t.Error("generated idempotency key not sent or recorded:", key, instance.IdempotencyKey)
+	}
+}
+
+func TestFindByIdempotencyKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/i" {
+			t.Error("wrong path:", r.URL.Path)
+		}
+		if r.URL.Query().Get("idempotencyKey") == "my-key" {
+			w.Write([]byte(`[{"id": "12345678", "type": "redis", "idempotencyKey": "my-key"}]`))
+		} else {
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance, err := client.FindByIdempotencyKey("my-key")
+	if err != nil {
+		t.Fatal("client.FindByIdempotencyKey returned an error:", err)
+	}
+	if instance.ID != "12345678" {
+		t.Error("wrong instance found:", instance.ID)
+	}
+	_, err = client.FindByIdempotencyKey("other-key")
+	if !IsNotFound(err) {
+		t.Error("unknown key should result in a not found error:", err)
+	}
+}
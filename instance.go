@@ -5,10 +5,13 @@
 package mktmpio
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Instance represents a server that has been created on the mktmpio service.
@@ -22,7 +25,47 @@ type Instance struct {
 	Username       string
 	Password       string
 	ContainerShell []string
-	client         Client
+	IdempotencyKey string
+	Version        string
+	MemoryMB       int           `json:"memory"`
+	TTL            time.Duration `json:"-"`
+	Labels         map[string]string
+	Region         string
+	Status         string
+	// SourceSnapshot is the ID of the snapshot the instance was created
+	// from, if any.
+	SourceSnapshot string
+	// CreatedAt is when the instance was created and ExpiresAt is when it
+	// will be destroyed unless it is extended. ExpiresAt is the zero time if
+	// the instance does not expire.
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	client    Provisioner
+}
+
+// UnmarshalJSON decodes an instance as returned by the mktmpio API, which
+// reports the TTL as a number of seconds.
+func (i *Instance) UnmarshalJSON(b []byte) error {
+	type instance Instance
+	aux := struct {
+		*instance
+		TTL int64 `json:"ttl"`
+	}{instance: (*instance)(i)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	i.TTL = time.Duration(aux.TTL) * time.Second
+	return nil
+}
+
+// MarshalJSON encodes an instance with the TTL as a number of seconds, as
+// the mktmpio API does, so that UnmarshalJSON can decode it again.
+func (i Instance) MarshalJSON() ([]byte, error) {
+	type instance Instance
+	return json.Marshal(struct {
+		instance
+		TTL int64 `json:"ttl"`
+	}{instance: instance(i), TTL: int64(i.TTL / time.Second)})
 }
 
 type shell struct {
@@ -32,7 +75,28 @@ type shell struct {
 
 // Destroy the server on the mktmpio service
 func (i *Instance) Destroy() error {
-	return i.client.Destroy(i.ID)
+	return i.provisioner().DestroyContext(context.Background(), i.ID)
+}
+
+// Refresh updates the instance in place with its current state on the
+// mktmpio service. If the instance no longer exists the returned error
+// satisfies IsNotFound.
+func (i *Instance) Refresh() error {
+	return i.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh but aborts the request if ctx is done before
+// the server has responded.
+func (i *Instance) RefreshContext(ctx context.Context) error {
+	current, err := i.provisioner().GetContext(ctx, i.ID)
+	if err != nil {
+		return err
+	}
+	if current.IdempotencyKey == "" {
+		current.IdempotencyKey = i.IdempotencyKey
+	}
+	*i = *current
+	return nil
 }
 
 // Cmd returns an exec.Cmd that is pre-populated with the command, arguments,
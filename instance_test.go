@@ -5,8 +5,13 @@
 package mktmpio
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadEnv(t *testing.T) {
@@ -99,3 +104,75 @@ func TestCmdWithEnv(t *testing.T) {
 		t.Error("required shell env var not set:", cmd.Env[len(cmd.Env)-1])
 	}
 }
+
+func TestRefresh(t *testing.T) {
+	destroyed := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if destroyed {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": "not found"}`))
+			return
+		}
+		w.Write([]byte(`{"id": "someId", "host": "new-host", "port": 4321, "type": "mktmpdb",
+			"username": "user2", "password": "pass2", "status": "running"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance := Instance{
+		ID:             "someId",
+		Host:           "some-host",
+		Port:           1234,
+		Type:           "mktmpdb",
+		Status:         "starting",
+		IdempotencyKey: "key",
+		client:         *client,
+	}
+	if err := instance.Refresh(); err != nil {
+		t.Fatal("Refresh returned an error:", err)
+	}
+	if instance.Host != "new-host" || instance.Port != 4321 || instance.Username != "user2" ||
+		instance.Password != "pass2" || instance.Status != "running" {
+		t.Errorf("instance not updated: %+v", instance)
+	}
+	if instance.IdempotencyKey != "key" {
+		t.Error("idempotency key was lost:", instance.IdempotencyKey)
+	}
+	destroyed = true
+	if err := instance.Refresh(); !IsNotFound(err) {
+		t.Error("Refresh of destroyed instance did not return not found error:", err)
+	}
+}
+
+func TestInstanceJSON(t *testing.T) {
+	instance := Instance{
+		ID:        "someId",
+		Type:      "postgres",
+		MemoryMB:  256,
+		TTL:       time.Hour,
+		Labels:    map[string]string{"ci": "true"},
+		ExpiresAt: time.Date(2017, 3, 1, 13, 0, 0, 0, time.UTC),
+	}
+	b, err := json.Marshal(instance)
+	if err != nil {
+		t.Fatal("Marshal returned an error:", err)
+	}
+	if !strings.Contains(string(b), `"ttl":3600`) || !strings.Contains(string(b), `"memory":256`) {
+		t.Errorf("instance not encoded like the API: %s", b)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal("Unmarshal returned an error:", err)
+	}
+	if _, ok := fields["TTL"]; ok {
+		t.Errorf("instance encoded the TTL twice: %s", b)
+	}
+	var decoded Instance
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal("Unmarshal returned an error:", err)
+	}
+	if decoded.TTL != time.Hour || decoded.MemoryMB != 256 || decoded.Labels["ci"] != "true" ||
+		!decoded.ExpiresAt.Equal(instance.ExpiresAt) {
+		t.Errorf("instance did not round-trip: %+v", decoded)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// KeepalivePolicy controls how a Client keeps the websockets of remote shells
+// and port forwards alive, and how it detects that the server stopped
+// responding on them.
+type KeepalivePolicy struct {
+	// Interval is how often a ping is sent to the server, keeping proxies
+	// from closing idle connections. Zero disables pings.
+	Interval time.Duration
+	// Timeout is how long to wait for any data, including the pongs that
+	// answer pings, or for a write to complete, before giving up on the
+	// connection with ErrIdleTimeout. It should be a few times Interval.
+	// Zero disables the timeout.
+	Timeout time.Duration
+}
+
+// DefaultKeepalivePolicy is the KeepalivePolicy used by clients created by
+// NewClient.
+var DefaultKeepalivePolicy = KeepalivePolicy{
+	Interval: 30 * time.Second,
+	Timeout:  90 * time.Second,
+}
+
+// WithKeepalive sets the KeepalivePolicy used for websocket connections.
+func WithKeepalive(p KeepalivePolicy) Option {
+	return func(c *Client) {
+		c.keepalive = p
+	}
+}
+
+// keepalive pings the server every interval until the websocket is closed
+// or a ping fails.
+func (c *wsConn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := frameCodec.Send(c.Conn, frame{kind: websocket.PingFrame}); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio/stdcopy"
+	"golang.org/x/net/websocket"
+)
+
+var fastKeepalive = KeepalivePolicy{
+	Interval: 10 * time.Millisecond,
+	Timeout:  100 * time.Millisecond,
+}
+
+func TestKeepalive(t *testing.T) {
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.PayloadType = websocket.BinaryFrame
+		// pings are answered while reading stdin, which stays open well
+		// past the timeout
+		go ioutil.ReadAll(ws)
+		time.Sleep(5 * fastKeepalive.Timeout)
+		stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("done"))
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithKeepalive(fastKeepalive))
+	s, err := client.AttachStdioSession(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdioSession returned an error:", err)
+	}
+	out, err := ioutil.ReadAll(s.Stdout)
+	if err != nil || string(out) != "done" {
+		t.Errorf("idle session did not survive: %q %v", out, err)
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		// never read, so pings go unanswered
+		<-unblock
+	})
+	defer ts.Close()
+	defer close(unblock)
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithKeepalive(fastKeepalive))
+	s, err := client.AttachStdioSession(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdioSession returned an error:", err)
+	}
+	start := time.Now()
+	if err := s.Wait(); err != ErrIdleTimeout {
+		t.Errorf("expected ErrIdleTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*fastKeepalive.Timeout {
+		t.Errorf("timeout took too long: %s", elapsed)
+	}
+}
+
+func TestKeepaliveDisabled(t *testing.T) {
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.PayloadType = websocket.BinaryFrame
+		time.Sleep(50 * time.Millisecond)
+		stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("done"))
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithKeepalive(KeepalivePolicy{}))
+	s, err := client.AttachStdioSession(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdioSession returned an error:", err)
+	}
+	if out, err := ioutil.ReadAll(s.Stdout); err != nil || string(out) != "done" {
+		t.Errorf("wrong output: %q %v", out, err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// extendParams is the JSON request body sent when extending an instance.
+type extendParams struct {
+	TTL int64 `json:"ttl"`
+}
+
+// Extend renews the lifetime of the instance identified by `id` so that it
+// is destroyed `ttl` from now, rather than when its current TTL runs out, and
+// returns the instance's updated state. The ttl is rounded down to a whole
+// number of seconds.
+func (c Client) Extend(id string, ttl time.Duration) (*Instance, error) {
+	return c.ExtendContext(context.Background(), id, ttl)
+}
+
+// ExtendContext is like Extend but aborts the request if ctx is done before
+// the server has responded.
+func (c Client) ExtendContext(ctx context.Context, id string, ttl time.Duration) (*Instance, error) {
+	if err := validateExtension(ttl); err != nil {
+		return nil, err
+	}
+	instance := &Instance{client: c}
+	params := extendParams{TTL: int64(ttl / time.Second)}
+	if err := c.jsonRequest(ctx, "POST", "/i/"+id+"/extend", nil, params, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// ExtendContext renews the lifetime of the local instance identified by
+// `id` so that it is destroyed `ttl` from now. If the instance has already
+// expired, the returned error satisfies IsNotFound.
+func (l *Local) ExtendContext(ctx context.Context, id string, ttl time.Duration) (*Instance, error) {
+	if err := validateExtension(ttl); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inst, ok := l.instances[id]
+	// once the timer has fired the instance is being destroyed
+	if !ok || inst.timer != nil && !inst.timer.Stop() {
+		return nil, notFound("POST", "/i/"+id, "instance not found")
+	}
+	if inst.timer == nil {
+		inst.timer = time.AfterFunc(ttl, func() { l.DestroyContext(context.Background(), id) })
+	} else {
+		inst.timer.Reset(ttl)
+	}
+	inst.TTL = ttl
+	inst.ExpiresAt = time.Now().Add(ttl)
+	instance := inst.current()
+	return &instance, nil
+}
+
+// validateExtension checks the TTL an instance is extended by.
+func validateExtension(ttl time.Duration) error {
+	if ttl < time.Second {
+		return fmt.Errorf("invalid TTL %s, must be at least 1s", ttl)
+	}
+	return nil
+}
+
+// extender is implemented by the Provisioners that support Extend.
+type extender interface {
+	ExtendContext(ctx context.Context, id string, ttl time.Duration) (*Instance, error)
+}
+
+// Extend renews the instance's lifetime, as with Client.Extend, and updates
+// it in place.
+func (i *Instance) Extend(ctx context.Context, ttl time.Duration) error {
+	e, ok := i.provisioner().(extender)
+	if !ok {
+		return fmt.Errorf("%T does not support Extend", i.client)
+	}
+	current, err := e.ExtendContext(ctx, i.ID, ttl)
+	if err != nil {
+		return err
+	}
+	if current.IdempotencyKey == "" {
+		current.IdempotencyKey = i.IdempotencyKey
+	}
+	*i = *current
+	return nil
+}
+
+// LeaseOptions controls how Client.Lease keeps an instance alive.
+type LeaseOptions struct {
+	// TTL is the lifetime the instance is renewed for each time. It must be
+	// at least a second.
+	TTL time.Duration
+	// Interval is the delay between renewals, which must be well below TTL
+	// for the instance to survive a failed renewal. Defaults to a third of
+	// TTL.
+	Interval time.Duration
+}
+
+// Lease is a background task that keeps an instance alive by repeatedly
+// extending its TTL, started by Client.Lease.
+type Lease struct {
+	done      chan struct{}
+	mu        sync.Mutex
+	expiresAt time.Time
+	err       error
+}
+
+// Lease starts renewing the TTL of the instance identified by `id` in the
+// background until ctx is done, so that it outlives its original TTL for as
+// long as it is in use but is still reaped if the process dies. The TTL is
+// renewed right away and then every opts.Interval. Failed renewals are tried
+// again at the next interval, except if the instance no longer exists, which
+// stops the Lease.
+func (c Client) Lease(ctx context.Context, id string, opts LeaseOptions) *Lease {
+	l := &Lease{done: make(chan struct{})}
+	if err := validateExtension(opts.TTL); err != nil {
+		l.err = err
+		close(l.done)
+		return l
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = opts.TTL / 3
+	}
+	go l.keep(ctx, c, id, opts)
+	return l
+}
+
+func (l *Lease) keep(ctx context.Context, c Client, id string, opts LeaseOptions) {
+	defer close(l.done)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		instance, err := c.ExtendContext(ctx, id, opts.TTL)
+		if ctx.Err() != nil {
+			return
+		}
+		l.mu.Lock()
+		l.err = err
+		if err == nil {
+			l.expiresAt = instance.ExpiresAt
+		} else {
+			c.log().Printf("error extending instance %s: %s", id, err)
+		}
+		l.mu.Unlock()
+		if IsNotFound(err) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// Done returns a channel that is closed once the Lease has stopped renewing
+// the instance.
+func (l *Lease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns the error from the most recent renewal, or nil if it
+// succeeded.
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// ExpiresAt returns the expiry time reported by the most recent successful
+// renewal, or the zero time if there has been none.
+func (l *Lease) ExpiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt
+}
@@ -0,0 +1,118 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// extendServer answers Extend requests for instance 1234 with an expiry of
+// the requested TTL after a fixed time, counting them. Other instances are
+// not found.
+func extendServer(t *testing.T, extends *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" || r.URL.Path != "/i/1234/extend" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"instance not found"}`))
+			return
+		}
+		var params extendParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Error("invalid extend request:", err)
+		}
+		atomic.AddInt32(extends, 1)
+		expiresAt := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(params.TTL) * time.Second)
+		fmt.Fprintf(w, `{"id":"1234","ttl":%d,"createdAt":"2017-03-01T12:00:00Z","expiresAt":%q}`,
+			params.TTL, expiresAt.Format(time.RFC3339))
+	}))
+}
+
+func TestExtend(t *testing.T) {
+	var extends int32
+	ts := extendServer(t, &extends)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	created := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	instance, err := client.Extend("1234", 90*time.Minute)
+	if err != nil {
+		t.Fatal("Extend returned an error:", err)
+	}
+	if instance.TTL != 90*time.Minute || !instance.CreatedAt.Equal(created) || !instance.ExpiresAt.Equal(created.Add(90*time.Minute)) {
+		t.Errorf("wrong instance: %+v", instance)
+	}
+	instance = &Instance{ID: "1234", IdempotencyKey: "key", client: *client}
+	if err := instance.Extend(context.Background(), time.Hour); err != nil {
+		t.Fatal("Instance.Extend returned an error:", err)
+	}
+	if !instance.ExpiresAt.Equal(created.Add(time.Hour)) || instance.IdempotencyKey != "key" {
+		t.Errorf("instance not updated: %+v", instance)
+	}
+	if _, err := client.Extend("1234", time.Millisecond); err == nil {
+		t.Error("Extend with an invalid TTL did not fail")
+	}
+	if _, err := client.Extend("5678", time.Hour); !IsNotFound(err) {
+		t.Error("expected not found error, got", err)
+	}
+}
+
+// cancelTransport cancels a context when it sees the given request, so that
+// renewals are stopped at a deterministic point.
+type cancelTransport struct {
+	requests int32
+	cancelAt int32
+	cancel   func()
+}
+
+func (ct *cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&ct.requests, 1) == ct.cancelAt {
+		ct.cancel()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestLease(t *testing.T) {
+	var extends int32
+	ts := extendServer(t, &extends)
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	ct := &cancelTransport{cancelAt: 3, cancel: cancel}
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithHTTPClient(&http.Client{Transport: ct}))
+	lease := client.Lease(ctx, "1234", LeaseOptions{TTL: time.Minute, Interval: time.Millisecond})
+	<-lease.Done()
+	if lease.Err() != nil {
+		t.Error("Lease failed:", lease.Err())
+	}
+	if want := time.Date(2017, 3, 1, 12, 1, 0, 0, time.UTC); !lease.ExpiresAt().Equal(want) {
+		t.Errorf("wrong expiry: %s", lease.ExpiresAt())
+	}
+	if n := atomic.LoadInt32(&ct.requests); n != 3 {
+		t.Errorf("Lease made %d renewals, expected it to stop after the third", n)
+	}
+}
+
+func TestLeaseErrors(t *testing.T) {
+	var extends int32
+	ts := extendServer(t, &extends)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	lease := client.Lease(context.Background(), "5678", LeaseOptions{TTL: time.Minute, Interval: time.Millisecond})
+	<-lease.Done()
+	if !IsNotFound(lease.Err()) {
+		t.Error("Lease of a missing instance did not stop with not found error:", lease.Err())
+	}
+	lease = client.Lease(context.Background(), "1234", LeaseOptions{})
+	<-lease.Done()
+	if lease.Err() == nil || extends != 0 {
+		t.Error("Lease with an invalid TTL did not fail")
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Local is a Provisioner that runs database servers as processes on the local
+// machine instead of on the mktmpio service. Each instance runs on a free
+// port on 127.0.0.1 with its data stored in a temporary directory that is
+// removed when the instance is destroyed. The server binaries for the
+// requested service, such as redis-server, must be installed and in $PATH.
+//
+// Remote shells attached to local instances run the service's command line
+// client locally, without a pseudo-TTY.
+type Local struct {
+	// Dir is the directory in which instance data directories are created.
+	// If empty, the system temporary directory is used.
+	Dir string
+
+	mu        sync.Mutex
+	instances map[string]*localInstance
+	order     []string
+}
+
+// localInstance is a running local database server.
+type localInstance struct {
+	Instance
+	dir   string
+	cmd   *exec.Cmd
+	done  chan struct{}
+	timer *time.Timer
+}
+
+// localService describes how to run a database server locally.
+type localService struct {
+	username string
+	// init returns the commands that prepare a new data directory.
+	init func(dir string) [][]string
+	// start returns the command that runs the server.
+	start func(dir string, port int) []string
+	// shell returns the command for connecting to the server.
+	shell func(port int) []string
+}
+
+var localServices = map[string]localService{
+	"redis": {
+		start: func(dir string, port int) []string {
+			return []string{"redis-server", "--port", strconv.Itoa(port), "--bind", "127.0.0.1",
+				"--dir", dir, "--save", "", "--appendonly", "no"}
+		},
+		shell: func(port int) []string {
+			return []string{"redis-cli", "-h", "127.0.0.1", "-p", strconv.Itoa(port)}
+		},
+	},
+	"postgres": {
+		username: "postgres",
+		init: func(dir string) [][]string {
+			return [][]string{{"initdb", "-D", filepath.Join(dir, "data"), "-U", "postgres", "--auth=trust"}}
+		},
+		start: func(dir string, port int) []string {
+			return []string{"postgres", "-D", filepath.Join(dir, "data"), "-p", strconv.Itoa(port),
+				"-k", dir, "-c", "listen_addresses=127.0.0.1"}
+		},
+		shell: func(port int) []string {
+			return []string{"psql", "-h", "127.0.0.1", "-p", strconv.Itoa(port), "-U", "postgres"}
+		},
+	},
+	"mysql": {
+		username: "root",
+		init: func(dir string) [][]string {
+			return [][]string{{"mysqld", "--initialize-insecure", "--datadir=" + filepath.Join(dir, "data")}}
+		},
+		start: func(dir string, port int) []string {
+			return []string{"mysqld", "--datadir=" + filepath.Join(dir, "data"), "--port=" + strconv.Itoa(port),
+				"--bind-address=127.0.0.1", "--socket=" + filepath.Join(dir, "mysql.sock")}
+		},
+		shell: func(port int) []string {
+			return []string{"mysql", "-h", "127.0.0.1", "-P", strconv.Itoa(port), "-u", "root"}
+		},
+	},
+	"mongodb": {
+		start: func(dir string, port int) []string {
+			return []string{"mongod", "--dbpath", dir, "--port", strconv.Itoa(port), "--bind_ip", "127.0.0.1"}
+		},
+		shell: func(port int) []string {
+			return []string{"mongo", "--host", "127.0.0.1", "--port", strconv.Itoa(port)}
+		},
+	},
+}
+
+// NewLocal creates a Local provisioner storing instance data under dir, or
+// under the system temporary directory if dir is empty.
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir, instances: map[string]*localInstance{}}
+}
+
+// CreateWithOptionsContext starts a local server of the type specified by
+// `service`. Of the options, only Labels and TTL are used. If the server
+// binaries are not installed, the returned error wraps exec.ErrNotFound.
+func (l *Local) CreateWithOptionsContext(ctx context.Context, service string, opts CreateOptions) (*Instance, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	svc, ok := localServices[service]
+	if !ok {
+		return nil, fmt.Errorf("service %q is not supported by the local backend", service)
+	}
+	dir, err := ioutil.TempDir(l.Dir, "mktmpio-"+service+"-")
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.Create(filepath.Join(dir, "server.log"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	defer logFile.Close()
+	if svc.init != nil {
+		for _, args := range svc.init(dir) {
+			cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+			cmd.Stdout, cmd.Stderr = logFile, logFile
+			if err := cmd.Run(); err != nil {
+				os.RemoveAll(dir)
+				return nil, fmt.Errorf("%s: %w (see %s)", args[0], err, logFile.Name())
+			}
+		}
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	args := svc.start(dir, port)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	inst := &localInstance{dir: dir, cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(inst.done)
+	}()
+	shellCmd := svc.shell(port)
+	now := time.Now()
+	inst.Instance = Instance{
+		ID:             NewIdempotencyKey()[:8],
+		Host:           "127.0.0.1",
+		Port:           port,
+		Type:           service,
+		Username:       svc.username,
+		RemoteShell:    shell{Cmd: shellCmd},
+		ContainerShell: shellCmd,
+		IdempotencyKey: opts.IdempotencyKey,
+		TTL:            opts.TTL,
+		Labels:         opts.Labels,
+		Status:         "running",
+		CreatedAt:      now,
+		client:         l,
+	}
+	if opts.TTL > 0 {
+		inst.ExpiresAt = now.Add(opts.TTL)
+	}
+	l.mu.Lock()
+	if l.instances == nil {
+		l.instances = map[string]*localInstance{}
+	}
+	l.instances[inst.ID] = inst
+	l.order = append(l.order, inst.ID)
+	if opts.TTL > 0 {
+		id := inst.ID
+		inst.timer = time.AfterFunc(opts.TTL, func() { l.DestroyContext(context.Background(), id) })
+	}
+	instance := inst.Instance
+	l.mu.Unlock()
+	return &instance, nil
+}
+
+// ListContext returns the local instances that are currently running.
+func (l *Local) ListContext(ctx context.Context) ([]Instance, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	instances := make([]Instance, 0, len(l.order))
+	for _, id := range l.order {
+		instances = append(instances, l.instances[id].current())
+	}
+	return instances, nil
+}
+
+// GetContext returns the local instance identified by `id`.
+func (l *Local) GetContext(ctx context.Context, id string) (*Instance, error) {
+	inst, err := l.lookup("GET", id)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	instance := inst.current()
+	l.mu.Unlock()
+	return &instance, nil
+}
+
+// localStopTimeout is how long DestroyContext waits for a local server to
+// shut down cleanly before killing it.
+const localStopTimeout = 10 * time.Second
+
+// DestroyContext stops the local instance identified by `id` and deletes its
+// data directory. The server is asked to shut down cleanly and killed if it
+// has not done so within 10 seconds or by the time ctx is done.
+func (l *Local) DestroyContext(ctx context.Context, id string) error {
+	l.mu.Lock()
+	inst, ok := l.instances[id]
+	if !ok {
+		l.mu.Unlock()
+		return notFound("DELETE", "/i/"+id, "instance not found")
+	}
+	delete(l.instances, id)
+	for n, oid := range l.order {
+		if oid == id {
+			l.order = append(l.order[:n], l.order[n+1:]...)
+			break
+		}
+	}
+	if inst.timer != nil {
+		inst.timer.Stop()
+	}
+	l.mu.Unlock()
+	inst.stop(ctx)
+	return os.RemoveAll(inst.dir)
+}
+
+// stop sends the server SIGTERM and waits for it to exit, killing it if it
+// does not exit within localStopTimeout or before ctx is done.
+func (inst *localInstance) stop(ctx context.Context) {
+	if err := inst.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// not supported on Windows, or the server already exited
+		inst.cmd.Process.Kill()
+	}
+	timer := time.NewTimer(localStopTimeout)
+	defer timer.Stop()
+	select {
+	case <-inst.done:
+		return
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	inst.cmd.Process.Kill()
+	<-inst.done
+}
+
+// AttachContext runs the command line client for the instance identified by
+// `id`, returning a ReadWriteCloser connected to its stdin and its combined
+// stdout and stderr. Closing it stops the client.
+func (l *Local) AttachContext(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	inst, err := l.lookup("GET", id)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, inst.ContainerShell[0], inst.ContainerShell[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	outReader, outWriter := io.Pipe()
+	cmd.Stdout, cmd.Stderr = outWriter, outWriter
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		outWriter.CloseWithError(cmd.Wait())
+	}()
+	return &localShell{Reader: outReader, WriteCloser: stdin, cmd: cmd}, nil
+}
+
+// AttachStdioContext runs the command line client for the instance
+// identified by `id`, returning its stdin, stdout and stderr.
+func (l *Local) AttachStdioContext(ctx context.Context, id string) (io.WriteCloser, io.Reader, io.Reader, error) {
+	inst, err := l.lookup("GET", id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cmd := exec.CommandContext(ctx, inst.ContainerShell[0], inst.ContainerShell[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	cmd.Stdout, cmd.Stderr = outWriter, errWriter
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	go func() {
+		err := cmd.Wait()
+		if _, ok := err.(*exec.ExitError); ok {
+			err = nil
+		}
+		errWriter.CloseWithError(err)
+		outWriter.CloseWithError(err)
+	}()
+	return stdin, outReader, errReader, nil
+}
+
+func (l *Local) lookup(method, id string) (*localInstance, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inst, ok := l.instances[id]
+	if !ok {
+		return nil, notFound(method, "/i/"+id, "instance not found")
+	}
+	return inst, nil
+}
+
+// current returns the instance with its status updated to reflect whether
+// the server process is still running. l.mu must be held.
+func (inst *localInstance) current() Instance {
+	instance := inst.Instance
+	select {
+	case <-inst.done:
+		instance.Status = "failed"
+	default:
+	}
+	return instance
+}
+
+// localShell is a command line client attached with Local.AttachContext.
+type localShell struct {
+	io.Reader
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (s *localShell) Close() error {
+	err := s.WriteCloser.Close()
+	s.cmd.Process.Kill()
+	return err
+}
+
+// freePort returns a TCP port on 127.0.0.1 that is not currently in use.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// notFound creates the same error the mktmpio API returns for resources that
+// do not exist.
+func notFound(method, path, msg string) *APIError {
+	return &APIError{
+		StatusCode: http.StatusNotFound,
+		Message:    msg,
+		Method:     method,
+		Path:       path,
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProvisioner(t *testing.T) {
+	if p, err := NewProvisioner(&Config{Token: "token"}); err != nil {
+		t.Error("NewProvisioner returned an error:", err)
+	} else if _, ok := p.(*Client); !ok {
+		t.Errorf("default backend is not a Client: %T", p)
+	}
+	if p, err := NewProvisioner(&Config{Backend: BackendLocal}); err != nil {
+		t.Error("NewProvisioner returned an error:", err)
+	} else if _, ok := p.(*Local); !ok {
+		t.Errorf("local backend is not a Local: %T", p)
+	}
+	if _, err := NewProvisioner(&Config{Backend: "bogus"}); err == nil {
+		t.Error("NewProvisioner accepted an unknown backend")
+	}
+}
+
+func TestLocalUnsupported(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	if _, err := l.CreateWithOptionsContext(context.Background(), "oracle", CreateOptions{}); err == nil {
+		t.Error("Local created an unsupported service")
+	}
+	if err := l.DestroyContext(context.Background(), "12345678"); !IsNotFound(err) {
+		t.Error("Local did not return not found error:", err)
+	}
+	if _, err := l.ExtendContext(context.Background(), "12345678", time.Hour); !IsNotFound(err) {
+		t.Error("Local did not return not found error from Extend:", err)
+	}
+	l.instances["12345678"] = &localInstance{Instance: Instance{ID: "12345678"}}
+	if _, err := l.Exec(context.Background(), "12345678", nil, nil); err == nil {
+		t.Error("Local ran an empty command")
+	}
+}
+
+func TestLocalDestroy(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh to be installed")
+	}
+	l := NewLocal(t.TempDir())
+	marker := filepath.Join(t.TempDir(), "stopped")
+	cmd := exec.Command("sh", "-c", `trap 'echo > "$0"; exit 0' TERM; echo ready; while :; do sleep 0.01; done`, marker)
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	bufio.NewReader(stdout).ReadString('\n')
+	inst := &localInstance{Instance: Instance{ID: "12345678"}, dir: t.TempDir(), cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(inst.done)
+	}()
+	l.instances[inst.ID] = inst
+	l.order = append(l.order, inst.ID)
+
+	errs := make(chan error, 2)
+	for n := 0; n < 2; n++ {
+		go func() { errs <- l.DestroyContext(context.Background(), inst.ID) }()
+	}
+	first, second := <-errs, <-errs
+	if (first == nil) == (second == nil) || !IsNotFound(first) && !IsNotFound(second) {
+		t.Errorf("expected one Destroy to succeed and the other to find nothing, got %v and %v", first, second)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("server was not asked to shut down cleanly:", err)
+	}
+}
+
+func TestLocalExtendExpired(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	expired := make(chan struct{})
+	inst := &localInstance{Instance: Instance{ID: "12345678"}}
+	inst.timer = time.AfterFunc(0, func() { close(expired) })
+	l.instances[inst.ID] = inst
+	<-expired
+	if _, err := l.ExtendContext(context.Background(), inst.ID, time.Hour); !IsNotFound(err) {
+		t.Error("Local extended an instance that had expired:", err)
+	}
+}
+
+func TestLocalRedis(t *testing.T) {
+	if _, err := exec.LookPath("redis-server"); err != nil {
+		t.Skip("requires redis-server to be installed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	l := NewLocal(t.TempDir())
+	redis, err := l.CreateWithOptionsContext(ctx, "redis", CreateOptions{})
+	if err != nil {
+		t.Fatal("Error creating local redis:", err)
+	}
+	defer redis.Destroy()
+	if err := redis.WaitReady(ctx); err != nil {
+		t.Fatal("local redis not ready:", err)
+	}
+	if redis.CreatedAt.IsZero() || !redis.ExpiresAt.IsZero() {
+		t.Errorf("wrong expiry information: %s %s", redis.CreatedAt, redis.ExpiresAt)
+	}
+	if err := redis.Extend(ctx, time.Hour); err != nil || redis.TTL != time.Hour || redis.ExpiresAt.Before(redis.CreatedAt.Add(time.Hour)) {
+		t.Errorf("Extend did not renew the TTL: %v %+v", err, redis)
+	}
+	stdin, stdout, _, err := l.AttachStdioContext(ctx, redis.ID)
+	if err != nil {
+		t.Fatal("Error attaching to local redis:", err)
+	}
+	io.WriteString(stdin, "ping\n")
+	stdin.Close()
+	if line, _ := bufio.NewReader(stdout).ReadString('\n'); !strings.Contains(line, "PONG") {
+		t.Errorf("unexpected reply from local redis: %q", line)
+	}
+	if err := redis.Destroy(); err != nil {
+		t.Error("Error destroying local redis:", err)
+	}
+	if list, _ := l.ListContext(ctx); len(list) != 0 {
+		t.Error("destroyed instance still listed:", list)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+// Package mktmpiotest provides helpers for using mktmpio instances as
+// fixtures in Go tests.
+//
+// A typical test creates an instance, connects to it and relies on the
+// instance being destroyed automatically when the test finishes:
+//
+//	func TestWithRedis(t *testing.T) {
+//		redis := mktmpiotest.New(t, "redis")
+//		conn, err := net.Dial("tcp", redis.Host+":"+strconv.Itoa(redis.Port))
+//		...
+//	}
+//
+// Tests using these helpers are skipped when no mktmpio token is configured.
+// Setting MKTMPIO_BACKEND=local runs the instances as local processes instead,
+// in which case tests are skipped if the service is not installed.
+package mktmpiotest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+// Timeout limits how long New waits for an instance to be created and become
+// ready before failing the test.
+var Timeout = 2 * time.Minute
+
+// New creates an instance of the given service and waits for it to be ready
+// to accept connections. The instance is destroyed when the test and all its
+// subtests complete. If no mktmpio token is configured, the test is skipped.
+func New(t testing.TB, service string) *mktmpio.Instance {
+	t.Helper()
+	return NewWithOptions(t, service, mktmpio.CreateOptions{})
+}
+
+// NewWithOptions is like New but creates the instance with the given options.
+func NewWithOptions(t testing.TB, service string, opts mktmpio.CreateOptions) *mktmpio.Instance {
+	t.Helper()
+	p := newProvisioner(t)
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	instance, err := p.CreateWithOptionsContext(ctx, service, opts)
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("mktmpio: %s is not installed for the local backend: %s", service, err)
+	}
+	if err != nil {
+		t.Fatalf("mktmpio: could not create %s instance: %s", service, err)
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("mktmpio: test used %s instance %s at %s:%d", service, instance.ID, instance.Host, instance.Port)
+		}
+		if err := instance.Destroy(); err != nil && !mktmpio.IsNotFound(err) {
+			t.Errorf("mktmpio: could not destroy %s instance %s: %s", service, instance.ID, err)
+		}
+	})
+	if err := instance.WaitReady(ctx); err != nil {
+		t.Fatalf("mktmpio: %s instance %s did not become ready: %s", service, instance.ID, err)
+	}
+	return instance
+}
+
+// NewEnv is like New but also sets the environment variables described in
+// Instance.LoadEnv, such as REDIS_HOST and REDIS_PORT, restoring their
+// previous values when the test completes.
+func NewEnv(t testing.TB, service string) *mktmpio.Instance {
+	t.Helper()
+	instance := New(t, service)
+	prefix := strings.ToUpper(instance.Type) + "_"
+	for _, field := range []string{"HOST", "PORT", "USERNAME", "PASSWORD"} {
+		key := prefix + field
+		if old, ok := os.LookupEnv(key); ok {
+			t.Cleanup(func() { os.Setenv(key, old) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(key) })
+		}
+	}
+	if err := instance.LoadEnv(); err != nil {
+		t.Fatalf("mktmpio: could not set environment for %s instance %s: %s", service, instance.ID, err)
+	}
+	return instance
+}
+
+// newProvisioner creates the Provisioner selected by the user's mktmpio
+// config, skipping the test if the mktmpio service is selected but no token
+// has been configured.
+func newProvisioner(t testing.TB) mktmpio.Provisioner {
+	t.Helper()
+	cfg := mktmpio.LoadConfig()
+	if cfg.Token == "" && cfg.Backend != mktmpio.BackendLocal {
+		t.Skip("mktmpio: no token configured, set MKTMPIO_TOKEN or create " + mktmpio.MKtmpioCfgFile)
+	}
+	p, err := mktmpio.NewProvisioner(cfg)
+	if err != nil {
+		t.Fatalf("mktmpio: could not create provisioner: %s", err)
+	}
+	return p
+}
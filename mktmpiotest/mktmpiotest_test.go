@@ -0,0 +1,105 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpiotest
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// skipRecorder is a testing.TB that records whether the test was skipped.
+type skipRecorder struct {
+	testing.TB
+	skipped bool
+}
+
+func (r *skipRecorder) Skip(args ...interface{}) {
+	r.skipped = true
+	runtime.Goexit()
+}
+
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestNewSkipsWithoutToken(t *testing.T) {
+	setenv(t, "HOME", t.TempDir())
+	setenv(t, "MKTMPIO_TOKEN", "")
+	r := &skipRecorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		New(r, "redis")
+	}()
+	<-done
+	if !r.skipped {
+		t.Error("New did not skip the test without a token")
+	}
+}
+
+func TestNewCreatesAndDestroys(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	destroyed := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/new/tcpdb":
+			w.WriteHeader(201)
+			w.Write([]byte(`{"id": "12345678", "host": "127.0.0.1", "port": ` + port + `, "type": "tcpdb"}`))
+		case r.Method == "DELETE":
+			destroyed = r.URL.Path
+			w.WriteHeader(204)
+		default:
+			t.Error("unexpected request:", r.Method, r.URL)
+		}
+	}))
+	defer ts.Close()
+	setenv(t, "MKTMPIO_TOKEN", "token")
+	setenv(t, "MKTMPIO_URL", ts.URL)
+	t.Run("fixture", func(t *testing.T) {
+		instance := NewEnv(t, "tcpdb")
+		if instance.ID != "12345678" {
+			t.Error("wrong instance created:", instance.ID)
+		}
+		if os.Getenv("TCPDB_PORT") != port {
+			t.Error("environment not loaded:", os.Getenv("TCPDB_PORT"))
+		}
+		if destroyed != "" {
+			t.Error("instance destroyed before test completed")
+		}
+	})
+	if destroyed != "/i/12345678" {
+		t.Error("instance not destroyed after test:", destroyed)
+	}
+	if _, ok := os.LookupEnv("TCPDB_PORT"); ok {
+		t.Error("environment not restored after test")
+	}
+}
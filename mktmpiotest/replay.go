@@ -0,0 +1,40 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpiotest
+
+import (
+	"io"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+// Replay returns a ShellFunc that plays back the recording read from r, as
+// written by a mktmpio.Recorder. Output events are written to the shell's
+// stdout in order, without delay. At each input event the shell reads as
+// many bytes from stdin, exiting with status 1 if they differ from the
+// recorded input or stdin ends first. Otherwise it exits with status 0 at
+// the end of the recording. Resize events are ignored.
+func Replay(r io.Reader) (ShellFunc, error) {
+	rec, err := mktmpio.ReadRecording(r)
+	if err != nil {
+		return nil, err
+	}
+	return func(sh *Shell) int {
+		for _, e := range rec.Events {
+			switch e.Type {
+			case mktmpio.EventOutput:
+				if _, err := io.WriteString(sh.Stdout, e.Data); err != nil {
+					return 1
+				}
+			case mktmpio.EventInput:
+				buf := make([]byte, len(e.Data))
+				if _, err := io.ReadFull(sh.Stdin, buf); err != nil || string(buf) != e.Data {
+					return 1
+				}
+			}
+		}
+		return 0
+	}, nil
+}
@@ -0,0 +1,63 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpiotest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+func TestReplay(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Shell = func(sh *Shell) int {
+		io.WriteString(sh.Stdout, "redis> ")
+		buf := make([]byte, 5)
+		io.ReadFull(sh.Stdin, buf)
+		io.WriteString(sh.Stdout, "PONG\r\n")
+		return 0
+	}
+	client := s.Client()
+	instance, _ := client.Create("redis")
+
+	// record a session with the scripted shell
+	var file bytes.Buffer
+	rec, _ := mktmpio.NewRecorder(&file, mktmpio.RecordHeader{})
+	tty, err := client.AttachTTY(context.Background(), instance.ID, mktmpio.TTYOptions{})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	session := rec.RecordTTY(tty)
+	buf := make([]byte, 7)
+	io.ReadFull(session, buf)
+	io.WriteString(session, "ping\r")
+	buf = make([]byte, 6)
+	io.ReadFull(session, buf)
+	session.Close()
+
+	// and play it back
+	s.Shell, err = Replay(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal("Replay returned an error:", err)
+	}
+	res, err := instance.Exec(context.Background(), nil, strings.NewReader("ping\r"))
+	if err != nil {
+		t.Fatal("Exec returned an error:", err)
+	}
+	if string(res.Stdout) != "redis> PONG\r\n" {
+		t.Errorf("wrong output replayed: %q", res.Stdout)
+	}
+	if _, err := instance.Exec(context.Background(), nil, strings.NewReader("info\r")); err == nil {
+		t.Error("replay accepted the wrong input")
+	}
+	if _, err := Replay(strings.NewReader("not a recording")); err == nil {
+		t.Error("Replay accepted an invalid recording")
+	}
+}
@@ -0,0 +1,989 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpiotest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio"
+	"github.com/mktmpio/go-mktmpio/stdcopy"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultToken is the token accepted by a Server unless another one is set.
+const DefaultToken = "mktmpiotest-token"
+
+// Server is an in-process fake of the mktmpio API, for testing code that uses
+// a mktmpio.Client without network access or a real account. Instances
+// created on a Server are backed by a local TCP listener that answers the
+// readiness handshake of their service, so Instance.WaitReady succeeds, and
+// remote shells are handled by the Server's Shell function. Port forwarding
+// websockets are connected to the instance's listener.
+//
+// A Server is safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the fake API, for use as mktmpio.Config.URL.
+	URL string
+	// Token is the only API token accepted by the Server.
+	Token string
+	// Shell handles remote shell sessions opened with Client.Attach,
+	// Client.AttachStdio and Client.Exec. It defaults to EchoShell.
+	Shell ShellFunc
+	// LegacyStdio makes the Server behave like servers that predate the
+	// mktmpio.stdio.v2 protocol: stdin ends with a sentinel value rather
+	// than a framed half-close, and exit statuses are not reported.
+	LegacyStdio bool
+	// DisableResume makes the Server behave like servers without session
+	// tokens: a TTY session ends when its websocket does, and every
+	// websocket starts a new shell.
+	DisableResume bool
+
+	ts        *httptest.Server
+	mu        sync.Mutex
+	instances map[string]*fakeInstance
+	order     []string
+	snapshots []*fakeSnapshot
+	keys      map[string]string
+	faults    []*Fault
+	requests  []Request
+	sessions  map[string]*ttySession
+	conns     map[net.Conn]struct{}
+}
+
+// Shell describes a remote shell session opened on a fake instance.
+type Shell struct {
+	Instance mktmpio.Instance
+	// TTY is true for sessions opened with Attach, in which case Stdout and
+	// Stderr are the same stream.
+	TTY bool
+	// Args holds the command requested with Client.Exec, or nil if the
+	// client asked for the instance's default shell.
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Size is the initial size of the TTY requested by the client, if any.
+	Size WindowSize
+	// Resize receives the new size of the TTY each time the client resizes
+	// it.
+	Resize <-chan WindowSize
+}
+
+// WindowSize is the size of a TTY.
+type WindowSize struct {
+	Rows int
+	Cols int
+}
+
+// frame is a single websocket message along with its payload type.
+type frame struct {
+	kind byte
+	data []byte
+}
+
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		f := v.(frame)
+		return f.data, f.kind, nil
+	},
+	Unmarshal: func(data []byte, kind byte, v interface{}) error {
+		f := v.(*frame)
+		f.kind, f.data = kind, data
+		return nil
+	},
+}
+
+// ShellFunc implements a fake remote shell, returning its exit status once
+// the session is over.
+type ShellFunc func(sh *Shell) int
+
+// EchoShell is a ShellFunc that copies everything from stdin to stdout.
+func EchoShell(sh *Shell) int {
+	io.Copy(sh.Stdout, sh.Stdin)
+	return 0
+}
+
+// Fault describes a failure for a Server to inject into the responses to
+// matching requests.
+type Fault struct {
+	// Method and Path select the requests that fail. Empty values match any
+	// request and a Path ending in "/" matches any path with that prefix.
+	Method string
+	Path   string
+	// Status and Message are the HTTP status code and error message of the
+	// failed response. Header is added to the response headers.
+	Status  int
+	Message string
+	Header  http.Header
+	// Drop closes the connection without sending any response, instead of
+	// responding with Status.
+	Drop bool
+	// Times is the number of requests to fail, after which the Fault is
+	// removed. Zero means the Fault never expires.
+	Times int
+}
+
+// Request records a request received by a Server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+// fakeInstance is the Server's state for an instance, serialized in the same
+// format as the real API.
+type fakeInstance struct {
+	ID             string            `json:"id"`
+	Host           string            `json:"host"`
+	Port           int               `json:"port"`
+	Type           string            `json:"type"`
+	Status         string            `json:"status"`
+	Username       string            `json:"username"`
+	Password       string            `json:"password"`
+	RemoteShell    fakeShell         `json:"remoteShell"`
+	ContainerShell []string          `json:"containerShell"`
+	IdempotencyKey string            `json:"idempotencyKey,omitempty"`
+	SourceSnapshot string            `json:"sourceSnapshot,omitempty"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	ExpiresAt      *time.Time        `json:"expiresAt,omitempty"`
+	Version        string            `json:"version,omitempty"`
+	Memory         int               `json:"memory,omitempty"`
+	TTL            int64             `json:"ttl,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Region         string            `json:"region,omitempty"`
+
+	listener net.Listener
+}
+
+// fakeSnapshot is the Server's record of a snapshot. Since fake instances
+// hold no data, it only remembers what the snapshot was taken of.
+type fakeSnapshot struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	InstanceID string    `json:"instanceId"`
+	Type       string    `json:"type"`
+	Version    string    `json:"version,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type fakeShell struct {
+	Cmd []string          `json:"cmd"`
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		Token:     DefaultToken,
+		Shell:     EchoShell,
+		instances: map[string]*fakeInstance{},
+		keys:      map[string]string{},
+		sessions:  map[string]*ttySession{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new/", s.handleNew)
+	mux.HandleFunc("/i", s.handleList)
+	mux.HandleFunc("/i/", s.handleInstance)
+	mux.HandleFunc("/s", s.handleSnapshots)
+	mux.HandleFunc("/s/", s.handleSnapshot)
+	mux.Handle("/ws", websocket.Server{Handshake: s.handshakeWS, Handler: s.handleWS})
+	s.ts = httptest.NewUnstartedServer(s.intercept(mux))
+	s.ts.Listener = &trackingListener{Listener: s.ts.Listener, s: s}
+	s.ts.Start()
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close shuts down the server, ending all of its TTY sessions and closing
+// its instances' listeners.
+func (s *Server) Close() {
+	s.ts.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inst := range s.instances {
+		inst.listener.Close()
+	}
+	for _, sess := range s.sessions {
+		sess.stdinWriter.Close()
+	}
+}
+
+// DropConnections closes every open connection to the Server abruptly,
+// without closing websockets cleanly, as a network failure would. TTY
+// sessions keep running so that clients can resume them.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = map[net.Conn]struct{}{}
+	s.mu.Unlock()
+	for conn := range conns {
+		conn.(*trackedConn).Conn.Close()
+	}
+}
+
+// trackingListener keeps track of the connections to a Server, so that
+// DropConnections can close them.
+type trackingListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tracked := &trackedConn{Conn: conn, s: l.s}
+	l.s.mu.Lock()
+	l.s.conns[tracked] = struct{}{}
+	l.s.mu.Unlock()
+	return tracked, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	s *Server
+}
+
+func (c *trackedConn) Close() error {
+	c.s.mu.Lock()
+	delete(c.s.conns, c)
+	c.s.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// Config returns a mktmpio.Config for connecting to the Server.
+func (s *Server) Config() *mktmpio.Config {
+	return &mktmpio.Config{Token: s.Token, URL: s.URL}
+}
+
+// Client returns a mktmpio.Client connected to the Server.
+func (s *Server) Client(opts ...mktmpio.Option) *mktmpio.Client {
+	client, _ := mktmpio.NewClient(s.Config(), opts...)
+	return client
+}
+
+// AddFault injects a failure into the responses for matching requests.
+// Faults are checked in the order they were added.
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Requests returns all of the requests received by the Server so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestCount returns the number of requests received by the Server that
+// match the given method and path, using the same rules as Fault.
+func (s *Server) RequestCount(method, path string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if matches(method, path, r.Method, r.Path) {
+			n++
+		}
+	}
+	return n
+}
+
+// Instances returns the instances that currently exist on the Server.
+func (s *Server) Instances() []mktmpio.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]mktmpio.Instance, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.instances[id].public())
+	}
+	return list
+}
+
+// Snapshots returns the snapshots that have been taken on the Server.
+func (s *Server) Snapshots() []mktmpio.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]mktmpio.Snapshot, 0, len(s.snapshots))
+	for _, snap := range s.snapshots {
+		var public mktmpio.Snapshot
+		b, _ := json.Marshal(snap)
+		json.Unmarshal(b, &public)
+		list = append(list, public)
+	}
+	return list
+}
+
+func matches(method, path, reqMethod, reqPath string) bool {
+	if method != "" && method != reqMethod {
+		return false
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.HasPrefix(reqPath, path)
+	}
+	return path == "" || path == reqPath
+}
+
+// intercept records every request, checks its token and injects any
+// matching Fault before passing it on to the API handlers.
+func (s *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+		fault := s.matchFault(r)
+		s.mu.Unlock()
+		if fault != nil {
+			if fault.Drop {
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+				return
+			}
+			for k, v := range fault.Header {
+				w.Header()[k] = v
+			}
+			writeError(w, fault.Status, fault.Message)
+			return
+		}
+		if r.Header.Get("X-Auth-Token") != s.Token {
+			writeError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchFault returns the first Fault matching r, consuming one of its Times.
+// s.mu must be held.
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for n, f := range s.faults {
+		if !matches(f.Method, f.Path, r.Method, r.URL.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:n], s.faults[n+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (s *Server) handleNew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	service := strings.TrimPrefix(r.URL.Path, "/new/")
+	if service == "" || strings.Contains(service, "/") {
+		writeError(w, http.StatusBadRequest, "unsupported type")
+		return
+	}
+	s.create(w, r, service, nil)
+}
+
+// create creates an instance of service, or a clone of snap if it is not
+// nil, with the settings in the body of r.
+func (s *Server) create(w http.ResponseWriter, r *http.Request, service string, snap *fakeSnapshot) {
+	var params struct {
+		Version string            `json:"version"`
+		Memory  int               `json:"memory"`
+		TTL     int64             `json:"ttl"`
+		Labels  map[string]string `json:"labels"`
+		Region  string            `json:"region"`
+	}
+	if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+		if err := json.Unmarshal(body, &params); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	key := r.Header.Get("Idempotency-Key")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.keys[key]; ok && key != "" {
+		if inst, ok := s.instances[id]; ok {
+			writeJSON(w, http.StatusCreated, inst)
+			return
+		}
+	}
+	inst, err := newFakeInstance(service)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	inst.IdempotencyKey = key
+	inst.Version = params.Version
+	if snap != nil {
+		inst.Version = snap.Version
+		inst.SourceSnapshot = snap.ID
+	}
+	inst.Memory = params.Memory
+	if params.TTL > 0 {
+		inst.extend(params.TTL)
+	}
+	inst.Labels = params.Labels
+	inst.Region = params.Region
+	s.instances[inst.ID] = inst
+	s.order = append(s.order, inst.ID)
+	if key != "" {
+		s.keys[key] = inst.ID
+	}
+	writeJSON(w, http.StatusCreated, inst)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	key := r.URL.Query().Get("idempotencyKey")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []*fakeInstance{}
+	for _, id := range s.order {
+		inst := s.instances[id]
+		if key == "" || inst.IdempotencyKey == key {
+			list = append(list, inst)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleInstance(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/i/")
+	id, sub := splitPath(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.instances[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	switch sub {
+	case "":
+	case "snapshots":
+		s.takeSnapshot(w, r, inst)
+		return
+	case "extend":
+		extendInstance(w, r, inst)
+		return
+	default:
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, inst)
+	case "DELETE":
+		inst.listener.Close()
+		delete(s.instances, id)
+		for n, oid := range s.order {
+			if oid == id {
+				s.order = append(s.order[:n], s.order[n+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// splitPath splits the first segment off path.
+func splitPath(path string) (string, string) {
+	if n := strings.Index(path, "/"); n >= 0 {
+		return path[:n], path[n+1:]
+	}
+	return path, ""
+}
+
+// extendInstance renews the TTL of inst. Instances are not reaped when they
+// expire, but their expiry time is reported. s.mu must be held.
+func extendInstance(w http.ResponseWriter, r *http.Request, inst *fakeInstance) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var params struct {
+		TTL int64 `json:"ttl"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &params); err != nil || params.TTL <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid ttl")
+		return
+	}
+	inst.extend(params.TTL)
+	writeJSON(w, http.StatusOK, inst)
+}
+
+// takeSnapshot records a snapshot of inst. s.mu must be held.
+func (s *Server) takeSnapshot(w http.ResponseWriter, r *http.Request, inst *fakeInstance) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+		if err := json.Unmarshal(body, &params); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	snap := &fakeSnapshot{
+		ID:         randomHex(4),
+		Name:       params.Name,
+		InstanceID: inst.ID,
+		Type:       inst.Type,
+		Version:    inst.Version,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	s.snapshots = append(s.snapshots, snap)
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, append([]*fakeSnapshot{}, s.snapshots...))
+}
+
+// handleSnapshot creates instances from snapshots.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	id, sub := splitPath(strings.TrimPrefix(r.URL.Path, "/s/"))
+	if sub != "new" {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	s.mu.Lock()
+	var snap *fakeSnapshot
+	for _, candidate := range s.snapshots {
+		if candidate.ID == id {
+			snap = candidate
+		}
+	}
+	s.mu.Unlock()
+	if snap == nil {
+		writeError(w, http.StatusNotFound, "snapshot not found")
+		return
+	}
+	s.create(w, r, snap.Type, snap)
+}
+
+// stdinEOF is the sentinel AttachStdio sends to indicate the end of stdin
+// with the legacy stdio protocol.
+var stdinEOF = []byte{255, 255, 255, 255}
+
+// stdioProtocolV2 is the websocket subprotocol for stdio sessions with
+// framed stdin and exit statuses.
+const stdioProtocolV2 = "mktmpio.stdio.v2"
+
+// handshakeWS selects the stdio protocol, if the client offered one the
+// Server supports.
+func (s *Server) handshakeWS(cfg *websocket.Config, r *http.Request) error {
+	offered := cfg.Protocol
+	cfg.Protocol = nil
+	s.mu.Lock()
+	legacy := s.LegacyStdio
+	s.mu.Unlock()
+	for _, p := range offered {
+		if p == stdioProtocolV2 && !legacy {
+			cfg.Protocol = []string{p}
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleWS(ws *websocket.Conn) {
+	defer ws.Close()
+	r := ws.Request()
+	s.mu.Lock()
+	inst, ok := s.instances[r.URL.Query().Get("id")]
+	shellFunc := s.Shell
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	if r.URL.Query().Get("forward") == "true" {
+		forward(ws, inst)
+		return
+	}
+	if r.URL.Query().Get("stdio") != "true" {
+		s.handleTTY(ws, inst, shellFunc)
+		return
+	}
+	stdinReader, stdinWriter := io.Pipe()
+	sh := &Shell{
+		Instance: inst.public(),
+		Args:     r.URL.Query()["cmd"],
+		Stdin:    stdinReader,
+		Stdout:   stdcopy.NewStdWriter(ws, stdcopy.Stdout),
+		Stderr:   stdcopy.NewStdWriter(ws, stdcopy.Stderr),
+	}
+	framed := len(ws.Config().Protocol) == 1
+	go func() {
+		var err error
+		if framed {
+			_, err = stdcopy.StdinCopy(stdinWriter, ws)
+		} else {
+			err = readStdin(ws, false, stdinWriter, nil)
+		}
+		stdinWriter.CloseWithError(err)
+		// keep reading so that pings are answered
+		io.Copy(ioutil.Discard, ws)
+	}()
+	status := shellFunc(sh)
+	stdinReader.Close()
+	if framed {
+		stdcopy.WriteExitStatus(ws, status)
+	}
+}
+
+// handleTTY attaches ws to a TTY session, resuming the one named by the
+// session query param if it is still running.
+func (s *Server) handleTTY(ws *websocket.Conn, inst *fakeInstance, shellFunc ShellFunc) {
+	query := ws.Request().URL.Query()
+	rows, _ := strconv.Atoi(query.Get("rows"))
+	cols, _ := strconv.Atoi(query.Get("cols"))
+	s.mu.Lock()
+	resumable := !s.DisableResume
+	sess := s.sessions[query.Get("session")]
+	if sess == nil {
+		sess = newTTYSession()
+		if resumable {
+			s.sessions[sess.token] = sess
+		}
+		sh := &Shell{
+			Instance: inst.public(),
+			TTY:      true,
+			Stdin:    sess.stdinReader,
+			Stdout:   sess,
+			Stderr:   sess,
+			Size:     WindowSize{Rows: rows, Cols: cols},
+			Resize:   sess.resize,
+		}
+		go func() {
+			shellFunc(sh)
+			s.mu.Lock()
+			delete(s.sessions, sess.token)
+			s.mu.Unlock()
+			sess.exit()
+		}()
+	} else if rows > 0 && cols > 0 {
+		sess.resized(WindowSize{Rows: rows, Cols: cols})
+	}
+	s.mu.Unlock()
+	if resumable {
+		msg, _ := json.Marshal(map[string]string{"type": "session", "token": sess.token})
+		frameCodec.Send(ws, frame{kind: websocket.TextFrame, data: msg})
+	}
+	if !sess.attach(ws) {
+		return
+	}
+	err := readStdin(ws, true, sess.stdinWriter, sess.resized)
+	sess.detach(ws)
+	if !resumable {
+		sess.stdinWriter.CloseWithError(err)
+	}
+}
+
+// readStdin copies the stdin of TTY and legacy stdio sessions from ws, which
+// sends each chunk of input in its own message, until the legacy stdin EOF
+// sentinel or an error.
+func readStdin(ws *websocket.Conn, tty bool, stdin io.Writer, resized func(WindowSize)) error {
+	for {
+		var f frame
+		if err := frameCodec.Receive(ws, &f); err != nil {
+			return err
+		}
+		if f.kind == websocket.TextFrame && tty {
+			var msg struct {
+				Type string `json:"type"`
+				Rows int    `json:"rows"`
+				Cols int    `json:"cols"`
+			}
+			if json.Unmarshal(f.data, &msg) == nil && msg.Type == "resize" {
+				resized(WindowSize{Rows: msg.Rows, Cols: msg.Cols})
+				continue
+			}
+		}
+		if !tty && bytes.Equal(f.data, stdinEOF) {
+			return nil
+		}
+		if _, err := stdin.Write(f.data); err != nil {
+			return err
+		}
+	}
+}
+
+// ttySession is a TTY shell that outlives its websocket, so that clients can
+// resume it after losing their connection. Output written while no websocket
+// is attached is held until one is.
+type ttySession struct {
+	token       string
+	stdinReader *io.PipeReader
+	stdinWriter *io.PipeWriter
+	resize      chan WindowSize
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	backlog []byte
+	exited  bool
+}
+
+func newTTYSession() *ttySession {
+	sess := &ttySession{
+		token:  randomHex(16),
+		resize: make(chan WindowSize, 16),
+	}
+	sess.stdinReader, sess.stdinWriter = io.Pipe()
+	return sess
+}
+
+// Write sends shell output to the attached websocket.
+func (t *ttySession) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ws != nil {
+		if _, err := t.ws.Write(p); err == nil {
+			return len(p), nil
+		}
+		t.ws = nil
+	}
+	t.backlog = append(t.backlog, p...)
+	return len(p), nil
+}
+
+func (t *ttySession) resized(size WindowSize) {
+	select {
+	case t.resize <- size:
+	default:
+	}
+}
+
+// attach makes ws the session's websocket, flushing any held output, and
+// reports whether the shell is still running.
+func (t *ttySession) attach(ws *websocket.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.exited {
+		return false
+	}
+	if len(t.backlog) > 0 {
+		ws.Write(t.backlog)
+		t.backlog = nil
+	}
+	t.ws = ws
+	return true
+}
+
+func (t *ttySession) detach(ws *websocket.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ws == ws {
+		t.ws = nil
+	}
+}
+
+// exit closes the attached websocket once the shell has exited.
+func (t *ttySession) exit() {
+	t.stdinReader.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exited = true
+	if t.ws != nil {
+		t.ws.Close()
+	}
+}
+
+// forward tunnels a port forwarding websocket to the instance's listener.
+func forward(ws *websocket.Conn, inst *fakeInstance) {
+	conn, err := net.Dial("tcp", inst.listener.Addr().String())
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, ws)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ws, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// serviceShells are the commands used for the remote and container shells of
+// the well known services.
+var serviceShells = map[string][]string{
+	"postgres": {"psql"},
+	"mysql":    {"mysql"},
+	"redis":    {"redis-cli"},
+	"mongodb":  {"mongo"},
+}
+
+// newFakeInstance creates an instance of the given service, backed by a new
+// local listener.
+func newFakeInstance(service string) (*fakeInstance, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	inst := &fakeInstance{
+		ID:        randomHex(4),
+		Host:      "127.0.0.1",
+		Port:      l.Addr().(*net.TCPAddr).Port,
+		Type:      service,
+		Status:    "running",
+		Username:  "mktmpio",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Password:  randomHex(8),
+		listener:  l,
+	}
+	cmd, ok := serviceShells[service]
+	if !ok {
+		cmd = []string{service}
+	}
+	inst.ContainerShell = cmd
+	inst.RemoteShell = fakeShell{
+		Cmd: append(append([]string{}, cmd...), "-h", inst.Host, "-p", fmt.Sprint(inst.Port)),
+		Env: map[string]string{"MKTMPIO_PASSWORD": inst.Password},
+	}
+	go inst.serve()
+	return inst, nil
+}
+
+// extend sets the instance to expire ttl seconds from now.
+func (inst *fakeInstance) extend(ttl int64) {
+	expiresAt := time.Now().UTC().Truncate(time.Second).Add(time.Duration(ttl) * time.Second)
+	inst.TTL = ttl
+	inst.ExpiresAt = &expiresAt
+}
+
+// public converts the fake instance into a mktmpio.Instance, as a client
+// would receive it.
+func (inst *fakeInstance) public() mktmpio.Instance {
+	var i mktmpio.Instance
+	b, _ := json.Marshal(inst)
+	json.Unmarshal(b, &i)
+	return i
+}
+
+// serve accepts connections on the instance's listener and answers just
+// enough of the service's protocol for readiness checks to succeed.
+func (inst *fakeInstance) serve() {
+	for {
+		conn, err := inst.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handshake(inst.Type, conn)
+		}()
+	}
+}
+
+// maxMessage bounds the length of the messages that handshake reads, which
+// is taken from the client.
+const maxMessage = 1 << 20
+
+// validSize reports whether size is a plausible length of a postgres or
+// mongodb message, which includes the 4 bytes of the length itself.
+func validSize(size uint32) bool {
+	return size > 4 && size <= maxMessage
+}
+
+func handshake(service string, conn net.Conn) {
+	switch service {
+	case "postgres":
+		var size uint32
+		if binary.Read(conn, binary.BigEndian, &size) == nil && validSize(size) {
+			io.ReadFull(conn, make([]byte, size-4))
+			// AuthenticationCleartextPassword
+			conn.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 3})
+		}
+	case "mysql", "mariadb":
+		conn.Write([]byte{10, 0, 0, 0, 10, '5', '.', '7', 0, 0, 0, 0, 0})
+	case "redis":
+		r := bufio.NewReader(conn)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			conn.Write([]byte("+PONG\r\n"))
+		}
+	case "mongodb":
+		var size uint32
+		if binary.Read(conn, binary.LittleEndian, &size) == nil && validSize(size) {
+			io.ReadFull(conn, make([]byte, size-4))
+			// empty OP_REPLY
+			conn.Write([]byte{16, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0})
+		}
+	default:
+		io.Copy(ioutil.Discard, conn)
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpiotest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+var fastRetries = mktmpio.RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  10 * time.Millisecond,
+}
+
+func TestServerLifecycle(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	instance, err := client.CreateWithOptions("redis", mktmpio.CreateOptions{Version: "3.2", TTL: time.Hour})
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	if instance.Type != "redis" || instance.Version != "3.2" || instance.TTL != time.Hour {
+		t.Errorf("Create returned wrong instance: %+v", instance)
+	}
+	if err := instance.WaitReady(context.Background()); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+	list, err := client.List()
+	if err != nil || len(list) != 1 || list[0].ID != instance.ID {
+		t.Error("List did not return created instance:", list, err)
+	}
+	got, err := client.Get(instance.ID)
+	if err != nil || got.Password != instance.Password {
+		t.Error("Get did not return created instance:", got, err)
+	}
+	if err := instance.Destroy(); err != nil {
+		t.Error("Destroy returned an error:", err)
+	}
+	if err := instance.Refresh(); !mktmpio.IsNotFound(err) {
+		t.Error("destroyed instance still exists:", err)
+	}
+	if n := s.RequestCount("DELETE", "/i/"); n != 1 {
+		t.Error("wrong number of DELETE requests recorded:", n)
+	}
+}
+
+func TestServerSnapshots(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	golden, _ := client.CreateWithOptions("postgres", mktmpio.CreateOptions{Version: "9.6"})
+	snapshot, err := client.Snapshot(golden.ID, "golden")
+	if err != nil {
+		t.Fatal("Snapshot returned an error:", err)
+	}
+	if snapshot.Name != "golden" || snapshot.InstanceID != golden.ID || snapshot.CreatedAt.IsZero() {
+		t.Errorf("wrong snapshot: %+v", snapshot)
+	}
+	golden.Destroy()
+	list, err := client.ListSnapshots()
+	if err != nil || len(list) != 1 || list[0] != *snapshot {
+		t.Error("ListSnapshots did not return the snapshot:", list, err)
+	}
+	clone, err := client.CreateFromSnapshot(snapshot.ID)
+	if err != nil {
+		t.Fatal("CreateFromSnapshot returned an error:", err)
+	}
+	if clone.SourceSnapshot != snapshot.ID || clone.Type != "postgres" || clone.Version != "9.6" {
+		t.Errorf("wrong clone: %+v", clone)
+	}
+	if err := clone.WaitReady(context.Background()); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+	if _, err := client.CreateFromSnapshot("missing"); !mktmpio.IsNotFound(err) {
+		t.Error("expected not found error for missing snapshot, got", err)
+	}
+	if _, err := client.Snapshot(golden.ID, "gone"); !mktmpio.IsNotFound(err) {
+		t.Error("expected not found error for destroyed instance, got", err)
+	}
+}
+
+func TestServerExtend(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	if instance.CreatedAt.IsZero() || !instance.ExpiresAt.IsZero() {
+		t.Errorf("wrong expiry information: %+v", instance)
+	}
+	if err := instance.Extend(context.Background(), time.Hour); err != nil {
+		t.Fatal("Extend returned an error:", err)
+	}
+	if instance.TTL != time.Hour || instance.ExpiresAt.Sub(instance.CreatedAt) < time.Hour-time.Second {
+		t.Errorf("TTL not extended: %+v", instance)
+	}
+	if got, _ := client.Get(instance.ID); !got.ExpiresAt.Equal(instance.ExpiresAt) {
+		t.Errorf("extended expiry not stored: %s", got.ExpiresAt)
+	}
+}
+
+func TestServerReadiness(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	for _, service := range []string{"postgres", "mysql", "redis", "mongodb", "other"} {
+		instance, err := client.Create(service)
+		if err != nil {
+			t.Fatal("Create returned an error:", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := instance.WaitReady(ctx); err != nil {
+			t.Errorf("%s instance not ready: %s", service, err)
+		}
+		cancel()
+	}
+}
+
+func TestServerHandshakeLength(t *testing.T) {
+	for _, service := range []string{"postgres", "mongodb"} {
+		for _, size := range []uint32{0, 4, maxMessage + 1, 0xffffffff} {
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				handshake(service, server)
+				server.Close()
+				close(done)
+			}()
+			length := []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+			if service == "mongodb" {
+				length = []byte{byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)}
+			}
+			client.Write(length)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s: handshake did not reject length %d", service, size)
+			}
+			if n, _ := client.Read(make([]byte, 16)); n != 0 {
+				t.Errorf("%s: handshake replied to length %d", service, size)
+			}
+			client.Close()
+		}
+	}
+}
+
+func TestServerIdempotency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	a, err := client.CreateWithKey("redis", "my-key")
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	b, err := client.CreateWithKey("redis", "my-key")
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	if a.ID != b.ID || len(s.Instances()) != 1 {
+		t.Error("repeated key created a second instance:", a.ID, b.ID)
+	}
+	found, err := client.FindByIdempotencyKey("my-key")
+	if err != nil || found.ID != a.ID {
+		t.Error("instance not found by idempotency key:", found, err)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddFault(Fault{Method: "POST", Path: "/new/", Status: 503, Times: 1})
+	s.AddFault(Fault{Method: "GET", Path: "/i", Drop: true, Times: 1})
+	client := s.Client(mktmpio.WithRetryPolicy(fastRetries))
+	if _, err := client.Create("redis"); err != nil {
+		t.Error("Create was not retried after fault:", err)
+	}
+	if n := s.RequestCount("POST", "/new/redis"); n != 2 {
+		t.Error("wrong number of create requests:", n)
+	}
+	if _, err := client.List(); err != nil {
+		t.Error("List was not retried after dropped connection:", err)
+	}
+	s.AddFault(Fault{Status: 429, Message: "slow down"})
+	if _, err := client.List(); !mktmpio.IsRateLimited(err) {
+		t.Error("permanent fault not injected:", err)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client, _ := mktmpio.NewClient(&mktmpio.Config{Token: "wrong", URL: s.URL})
+	if _, err := client.List(); !mktmpio.IsUnauthorized(err) {
+		t.Error("wrong token was accepted:", err)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 1 || reqs[0].Header.Get("X-Auth-Token") != "wrong" {
+		t.Error("request not recorded:", reqs)
+	}
+}
+
+func TestServerAttachStdio(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Shell = func(sh *Shell) int {
+		data, _ := ioutil.ReadAll(sh.Stdin)
+		io.WriteString(sh.Stdout, strings.ToUpper(string(data)))
+		io.WriteString(sh.Stderr, sh.Instance.Type)
+		return 0
+	}
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	stdin, stdout, stderr, err := client.AttachStdio(instance.ID)
+	if err != nil {
+		t.Fatal("AttachStdio returned an error:", err)
+	}
+	go func() {
+		io.WriteString(stdin, "scan 0\n")
+		stdin.Close()
+	}()
+	errc := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(stderr)
+		errc <- data
+	}()
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "SCAN 0\n" {
+		t.Errorf("wrong stdout: %q", out)
+	}
+	if errOut := <-errc; string(errOut) != "redis" {
+		t.Errorf("wrong stderr: %q", errOut)
+	}
+}
+
+func TestServerExec(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Shell = func(sh *Shell) int {
+		io.WriteString(sh.Stdout, strings.Join(sh.Args, " "))
+		return len(sh.Args)
+	}
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	res, err := instance.Exec(context.Background(), []string{"redis-cli", "ping"}, nil)
+	var exitErr *mktmpio.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 2 {
+		t.Fatalf("expected exit status 2, got %v", err)
+	}
+	if string(res.Stdout) != "redis-cli ping" {
+		t.Errorf("wrong stdout: %q", res.Stdout)
+	}
+}
+
+func TestServerStdioProtocols(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	// the legacy EOF sentinel is ordinary input with the framed protocol
+	input := "a\xff\xff\xff\xffb"
+	res, err := instance.Exec(context.Background(), nil, strings.NewReader(input))
+	if err != nil {
+		t.Fatal("Exec returned an error:", err)
+	}
+	if string(res.Stdout) != input {
+		t.Errorf("wrong stdout: %q", res.Stdout)
+	}
+	s.LegacyStdio = true
+	_, err = instance.Exec(context.Background(), nil, strings.NewReader("ping"))
+	if err != mktmpio.ErrNoExitStatus {
+		t.Errorf("expected ErrNoExitStatus from legacy server, got %v", err)
+	}
+}
+
+func TestServerKeepalive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Shell = func(sh *Shell) int {
+		ioutil.ReadAll(sh.Stdin)
+		// a long running command, like loading a big dump
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(sh.Stdout, "done")
+		return 0
+	}
+	client := s.Client(mktmpio.WithKeepalive(mktmpio.KeepalivePolicy{
+		Interval: 10 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}))
+	instance, _ := client.Create("postgres")
+	res, err := instance.Exec(context.Background(), []string{"psql"}, strings.NewReader("select 1;"))
+	if err != nil || string(res.Stdout) != "done" {
+		t.Errorf("long running command did not survive: %v", err)
+	}
+}
+
+func TestServerAttach(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	rw, err := client.Attach(instance.ID)
+	if err != nil {
+		t.Fatal("Attach returned an error:", err)
+	}
+	defer rw.Close()
+	io.WriteString(rw, "ping\r\n")
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != "ping\r\n" {
+		t.Errorf("TTY not echoed: %q %v", buf, err)
+	}
+}
+
+func TestServerFaultStatus(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddFault(Fault{Path: "/i/", Status: http.StatusInternalServerError, Header: http.Header{"X-Request-Id": {"abc"}}})
+	_, err := s.Client(mktmpio.WithRetryPolicy(mktmpio.RetryPolicy{})).Get("anything")
+	apiErr, ok := err.(*mktmpio.APIError)
+	if !ok || apiErr.StatusCode != 500 || apiErr.RequestID != "abc" {
+		t.Error("fault not injected:", err)
+	}
+}
+
+func TestServerPortForward(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	f, err := client.PortForward(context.Background(), instance.ID, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("PortForward returned an error:", err)
+	}
+	defer f.Close()
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal("Could not connect to forwarded port:", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PING\r\n")
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "+PONG\r\n" {
+		t.Errorf("unexpected reply through tunnel: %q", line)
+	}
+}
+
+func TestServerResize(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	sizes := make(chan WindowSize, 2)
+	s.Shell = func(sh *Shell) int {
+		sizes <- sh.Size
+		sizes <- <-sh.Resize
+		return 0
+	}
+	client := s.Client()
+	instance, _ := client.Create("redis")
+	tty, err := client.AttachTTY(context.Background(), instance.ID, mktmpio.TTYOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	defer tty.Close()
+	if size := <-sizes; size != (WindowSize{24, 80}) {
+		t.Error("wrong initial size:", size)
+	}
+	tty.Resize(40, 100)
+	if size := <-sizes; size != (WindowSize{40, 100}) {
+		t.Error("wrong resized size:", size)
+	}
+}
+
+// countingShell echoes each byte of input prefixed with how many it has
+// seen, so that a new shell can be told apart from a resumed one.
+func countingShell(sh *Shell) int {
+	buf := make([]byte, 1)
+	for n := 1; ; n++ {
+		if _, err := sh.Stdin.Read(buf); err != nil {
+			return 0
+		}
+		fmt.Fprintf(sh.Stdout, "%d%s", n, buf)
+	}
+}
+
+func TestServerTTYReconnect(t *testing.T) {
+	for _, resume := range []bool{true, false} {
+		t.Run(fmt.Sprint("resume=", resume), func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			s.Shell = countingShell
+			s.DisableResume = !resume
+			client := s.Client()
+			instance, _ := client.Create("redis")
+			events := make(chan mktmpio.ReconnectEvent, 10)
+			tty, err := client.AttachTTY(context.Background(), instance.ID, mktmpio.TTYOptions{
+				Reconnect:   &fastRetries,
+				OnReconnect: func(e mktmpio.ReconnectEvent) { events <- e },
+			})
+			if err != nil {
+				t.Fatal("AttachTTY returned an error:", err)
+			}
+			defer tty.Close()
+			output := make(chan string)
+			go func() {
+				buf := make([]byte, 2)
+				for {
+					if _, err := io.ReadFull(tty, buf); err != nil {
+						close(output)
+						return
+					}
+					output <- string(buf)
+				}
+			}()
+			io.WriteString(tty, "a")
+			if out := <-output; out != "1a" {
+				t.Fatalf("wrong output: %q", out)
+			}
+			s.DropConnections()
+			for e := range events {
+				if e.State == mktmpio.Reconnected {
+					if e.Resumed != resume {
+						t.Errorf("expected Resumed=%v: %+v", resume, e)
+					}
+					break
+				}
+				if e.State != mktmpio.Reconnecting {
+					t.Fatalf("unexpected event: %+v", e)
+				}
+			}
+			io.WriteString(tty, "b")
+			expected := "2b"
+			if !resume {
+				expected = "1b"
+			}
+			if out := <-output; out != expected {
+				t.Errorf("expected %q after reconnecting, got %q", expected, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// CreateOptions holds the optional settings for a new instance. The zero
+// value requests the service's defaults.
+type CreateOptions struct {
+	// Version of the service to run, such as "9.6" for postgres. Defaults to
+	// the latest version supported by mktmp.io.
+	Version string
+	// MemoryMB is the amount of memory available to the instance, in MB.
+	MemoryMB int
+	// TTL is how long the instance lives before it is automatically
+	// destroyed. It is rounded down to a whole number of seconds.
+	TTL time.Duration
+	// Labels are arbitrary key/value pairs attached to the instance.
+	Labels map[string]string
+	// Region is the region the instance is created in, such as "us-west-1".
+	Region string
+	// IdempotencyKey is sent along with the request so that it can safely be
+	// repeated. A random key is generated if none is given.
+	IdempotencyKey string
+}
+
+var (
+	versionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+	labelPattern   = regexp.MustCompile(`^[0-9A-Za-z]([0-9A-Za-z._-]{0,61}[0-9A-Za-z])?$`)
+	regionPattern  = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+const maxLabelValue = 255
+
+// Validate checks that the options are well formed, without checking whether
+// the server supports the requested values.
+func (o CreateOptions) Validate() error {
+	if o.Version != "" && !versionPattern.MatchString(o.Version) {
+		return fmt.Errorf("invalid version %q", o.Version)
+	}
+	if o.MemoryMB < 0 {
+		return fmt.Errorf("invalid memory size %dMB", o.MemoryMB)
+	}
+	if o.TTL < 0 || (o.TTL > 0 && o.TTL < time.Second) {
+		return fmt.Errorf("invalid TTL %s, must be at least 1s", o.TTL)
+	}
+	for k, v := range o.Labels {
+		if !labelPattern.MatchString(k) {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+		if len(v) > maxLabelValue {
+			return fmt.Errorf("label %q value longer than %d bytes", k, maxLabelValue)
+		}
+	}
+	if o.Region != "" && !regionPattern.MatchString(o.Region) {
+		return fmt.Errorf("invalid region %q", o.Region)
+	}
+	return nil
+}
+
+// createParams is the JSON request body sent when creating an instance.
+type createParams struct {
+	Version string            `json:"version,omitempty"`
+	Memory  int               `json:"memory,omitempty"`
+	TTL     int64             `json:"ttl,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Region  string            `json:"region,omitempty"`
+}
+
+func (o CreateOptions) params() createParams {
+	return createParams{
+		Version: o.Version,
+		Memory:  o.MemoryMB,
+		TTL:     int64(o.TTL / time.Second),
+		Labels:  o.Labels,
+		Region:  o.Region,
+	}
+}
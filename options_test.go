@@ -0,0 +1,98 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateOptionsValidate(t *testing.T) {
+	valid := []CreateOptions{
+		{},
+		{Version: "9.6", MemoryMB: 512, TTL: time.Hour, Region: "us-west-1"},
+		{Labels: map[string]string{"team": "db", "ci.build": "1234"}},
+	}
+	for _, o := range valid {
+		if err := o.Validate(); err != nil {
+			t.Errorf("%+v should be valid: %s", o, err)
+		}
+	}
+	invalid := []CreateOptions{
+		{Version: "9.6; rm -rf"},
+		{MemoryMB: -1},
+		{TTL: -time.Second},
+		{TTL: time.Millisecond},
+		{Labels: map[string]string{"": "empty"}},
+		{Labels: map[string]string{"bad key": "value"}},
+		{Labels: map[string]string{"key": strings.Repeat("x", 256)}},
+		{Region: "US West"},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", o)
+		}
+	}
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	var params map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Error("wrong content type:", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Error("invalid request body:", err)
+		}
+		w.WriteHeader(201)
+		w.Write([]byte(`{
+			"id": "12345678",
+			"type": "postgres",
+			"version": "9.6",
+			"memory": 512,
+			"ttl": 3600,
+			"labels": {"team": "db"},
+			"region": "us-west-1"
+		}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance, err := client.CreateWithOptions("postgres", CreateOptions{
+		Version:  "9.6",
+		MemoryMB: 512,
+		TTL:      time.Hour,
+		Labels:   map[string]string{"team": "db"},
+		Region:   "us-west-1",
+	})
+	if err != nil {
+		t.Fatal("client.CreateWithOptions returned an error:", err)
+	}
+	if params["version"] != "9.6" || params["memory"] != 512.0 || params["ttl"] != 3600.0 || params["region"] != "us-west-1" {
+		t.Error("options not sent correctly:", params)
+	}
+	if instance.Version != "9.6" || instance.MemoryMB != 512 || instance.TTL != time.Hour ||
+		instance.Region != "us-west-1" || instance.Labels["team"] != "db" {
+		t.Errorf("options not reflected on instance: %+v", instance)
+	}
+}
+
+func TestCreateWithInvalidOptions(t *testing.T) {
+	requested := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	if _, err := client.CreateWithOptions("postgres", CreateOptions{MemoryMB: -1}); err == nil {
+		t.Error("client.CreateWithOptions did not return an error")
+	}
+	if requested {
+		t.Error("invalid options should not be sent to the server")
+	}
+}
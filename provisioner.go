@@ -0,0 +1,65 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Provisioner creates, inspects, destroys and attaches to database server
+// instances. Client implements Provisioner using the mktmpio service and
+// Local implements it by running database servers on the local machine, so
+// code written against a Provisioner can run against either.
+type Provisioner interface {
+	CreateWithOptionsContext(ctx context.Context, service string, opts CreateOptions) (*Instance, error)
+	ListContext(ctx context.Context) ([]Instance, error)
+	GetContext(ctx context.Context, id string) (*Instance, error)
+	DestroyContext(ctx context.Context, id string) error
+	AttachContext(ctx context.Context, id string) (io.ReadWriteCloser, error)
+	AttachStdioContext(ctx context.Context, id string) (io.WriteCloser, io.Reader, io.Reader, error)
+}
+
+var (
+	_ Provisioner = Client{}
+	_ Provisioner = (*Local)(nil)
+)
+
+// Backends that can be selected by Config.Backend.
+const (
+	BackendMktmpio = "mktmpio"
+	BackendLocal   = "local"
+)
+
+// NewProvisioner returns the Provisioner selected by cfg.Backend: a Client
+// for the mktmpio service if it is empty or "mktmpio", or a Local for
+// "local".
+func NewProvisioner(cfg *Config, opts ...Option) (Provisioner, error) {
+	switch cfg.Backend {
+	case "", BackendMktmpio:
+		return NewClient(cfg, opts...)
+	case BackendLocal:
+		return NewLocal(""), nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+}
+
+// provisioner returns the Provisioner that created the instance.
+func (i *Instance) provisioner() Provisioner {
+	if i.client == nil {
+		return Client{}
+	}
+	return i.client
+}
+
+// dialer returns the Dialer used for connecting to the instance.
+func (i *Instance) dialer() Dialer {
+	if c, ok := i.client.(Client); ok {
+		return c.dial()
+	}
+	return &net.Dialer{}
+}
@@ -0,0 +1,243 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WaitOptions controls how Instance.WaitReadyWithOptions checks for
+// readiness.
+type WaitOptions struct {
+	// Timeout is the maximum amount of time to wait for the instance to
+	// become ready, in addition to any deadline on the context.
+	Timeout time.Duration
+	// Interval is the delay between consecutive readiness checks.
+	Interval time.Duration
+}
+
+// DefaultWaitOptions are the WaitOptions used by Instance.WaitReady.
+var DefaultWaitOptions = WaitOptions{
+	Timeout:  2 * time.Minute,
+	Interval: 500 * time.Millisecond,
+}
+
+// probeTimeout limits how long a single readiness handshake may take, so that
+// a server that accepts connections but never responds is retried.
+const probeTimeout = 5 * time.Second
+
+// maxPostgresError is the largest ErrorResponse accepted by probePostgres,
+// so that garbage from whatever is listening cannot cause huge allocations.
+const maxPostgresError = 64 * 1024
+
+// ErrInstanceFailed is returned by WaitReady when the mktmpio service reports
+// that the instance will never become ready.
+var ErrInstanceFailed = errors.New("instance failed to start")
+
+// probeFunc performs a protocol specific handshake over conn and returns nil
+// if the server on the other end is ready to accept queries.
+type probeFunc func(i *Instance, conn net.Conn) error
+
+// probes contains the readiness checks for the services that mktmpio
+// provides. Services without a probe are considered ready once they accept
+// TCP connections.
+var probes = map[string]probeFunc{
+	"postgres": probePostgres,
+	"mysql":    probeMySQL,
+	"mariadb":  probeMySQL,
+	"redis":    probeRedis,
+	"mongodb":  probeMongoDB,
+}
+
+// WaitReady blocks until the instance is running and its database server is
+// accepting connections, using DefaultWaitOptions.
+func (i *Instance) WaitReady(ctx context.Context) error {
+	return i.WaitReadyWithOptions(ctx, DefaultWaitOptions)
+}
+
+// WaitReadyWithOptions blocks until the instance is running and its database
+// server is accepting connections. The instance's status is polled until the
+// service reports it as running, after which a service specific handshake is
+// attempted until it succeeds or the timeout expires.
+func (i *Instance) WaitReadyWithOptions(ctx context.Context, opts WaitOptions) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWaitOptions.Interval
+	}
+	var lastErr error
+	for {
+		lastErr = i.checkReady(ctx)
+		if lastErr == nil || lastErr == ErrInstanceFailed || IsNotFound(lastErr) {
+			return lastErr
+		}
+		timer := time.NewTimer(opts.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("instance %s not ready: %w (last error: %s)", i.ID, ctx.Err(), lastErr)
+		case <-timer.C:
+		}
+	}
+}
+
+// checkReady makes a single check of the instance's readiness.
+func (i *Instance) checkReady(ctx context.Context) error {
+	switch i.Status {
+	case "", "running", "ready":
+	case "failed", "error", "destroyed":
+		return ErrInstanceFailed
+	default:
+		if err := i.RefreshContext(ctx); err != nil {
+			return err
+		}
+		if i.Status != "running" && i.Status != "ready" {
+			return fmt.Errorf("instance status is %q", i.Status)
+		}
+	}
+	return i.probe(ctx)
+}
+
+// probe connects to the instance and performs its readiness handshake.
+func (i *Instance) probe(ctx context.Context) error {
+	addr := net.JoinHostPort(i.Host, strconv.Itoa(i.Port))
+	conn, err := i.dialer().DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(probeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if probe, ok := probes[i.Type]; ok {
+		return probe(i, conn)
+	}
+	return nil
+}
+
+// probePostgres sends a StartupMessage and considers the server ready if it
+// responds with anything other than an error saying it is not yet accepting
+// connections (SQLSTATE class 57P).
+func probePostgres(i *Instance, conn net.Conn) error {
+	var params bytes.Buffer
+	binary.Write(&params, binary.BigEndian, int32(196608)) // protocol 3.0
+	for _, kv := range []string{"user", i.Username, "database", i.Username} {
+		params.WriteString(kv)
+		params.WriteByte(0)
+	}
+	params.WriteByte(0)
+	msg := make([]byte, 4, 4+params.Len())
+	binary.BigEndian.PutUint32(msg, uint32(4+params.Len()))
+	if _, err := conn.Write(append(msg, params.Bytes()...)); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	kind, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if kind != 'E' {
+		return nil
+	}
+	var size int32
+	if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size < 4 || size > maxPostgresError {
+		return fmt.Errorf("postgres not ready: invalid error message length %d", size)
+	}
+	body := make([]byte, size-4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return err
+	}
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) > 0 && field[0] == 'C' {
+			if strings.HasPrefix(string(field[1:]), "57P") {
+				return fmt.Errorf("postgres not ready: SQLSTATE %s", field[1:])
+			}
+		}
+	}
+	return nil
+}
+
+// probeMySQL reads the server's initial handshake packet, which is an error
+// packet if the server is not accepting connections.
+func probeMySQL(i *Instance, conn net.Conn) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[4] == 0xff {
+		return errors.New("mysql not ready: server sent error packet")
+	}
+	return nil
+}
+
+// probeRedis sends a PING, which is answered even if authentication is
+// required, unless the server is still loading its dataset.
+func probeRedis(i *Instance, conn net.Conn) error {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "-LOADING") || strings.HasPrefix(reply, "-BUSY") {
+		return fmt.Errorf("redis not ready: %s", strings.TrimSpace(reply[1:]))
+	}
+	return nil
+}
+
+// probeMongoDB sends an isMaster command using OP_QUERY, which every version
+// of mongod supports for the initial handshake, and waits for an OP_REPLY.
+func probeMongoDB(i *Instance, conn net.Conn) error {
+	var doc bytes.Buffer
+	binary.Write(&doc, binary.LittleEndian, int32(19))
+	doc.WriteByte(0x10) // int32 element
+	doc.WriteString("isMaster\x00")
+	binary.Write(&doc, binary.LittleEndian, int32(1))
+	doc.WriteByte(0)
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, int32(0)) // flags
+	body.WriteString("admin.$cmd\x00")
+	binary.Write(&body, binary.LittleEndian, int32(0))  // numberToSkip
+	binary.Write(&body, binary.LittleEndian, int32(-1)) // numberToReturn
+	body.Write(doc.Bytes())
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.LittleEndian, int32(16+body.Len()))
+	binary.Write(&msg, binary.LittleEndian, int32(1))    // requestID
+	binary.Write(&msg, binary.LittleEndian, int32(0))    // responseTo
+	binary.Write(&msg, binary.LittleEndian, int32(2004)) // OP_QUERY
+	msg.Write(body.Bytes())
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if op := binary.LittleEndian.Uint32(header[12:]); op != 1 {
+		return fmt.Errorf("mongodb not ready: unexpected reply opcode %d", op)
+	}
+	return nil
+}
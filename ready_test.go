@@ -0,0 +1,160 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var fastWait = WaitOptions{Timeout: 5 * time.Second, Interval: 10 * time.Millisecond}
+
+// probeServer starts a TCP server that handles each connection with handle
+// and returns an Instance of the given type pointing at it.
+func probeServer(t *testing.T, service string, handle func(n int, conn net.Conn)) (*Instance, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(n int) {
+				handle(n, conn)
+				conn.Close()
+			}(n)
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	client, _ := NewClient(testConfig)
+	instance := &Instance{
+		ID:       "someId",
+		Host:     "127.0.0.1",
+		Port:     addr.Port,
+		Type:     service,
+		Username: "user",
+		client:   *client,
+	}
+	return instance, func() { l.Close() }
+}
+
+func TestWaitReadyRedis(t *testing.T) {
+	instance, stop := probeServer(t, "redis", func(n int, conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		if n < 3 {
+			conn.Write([]byte("-LOADING Redis is loading the dataset in memory\r\n"))
+		} else {
+			conn.Write([]byte("+PONG\r\n"))
+		}
+	})
+	defer stop()
+	if err := instance.WaitReadyWithOptions(context.Background(), fastWait); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+}
+
+func TestWaitReadyPostgres(t *testing.T) {
+	instance, stop := probeServer(t, "postgres", func(n int, conn net.Conn) {
+		header := make([]byte, 4)
+		io.ReadFull(conn, header)
+		io.ReadFull(conn, make([]byte, int(header[3])-4))
+		switch n {
+		case 1:
+			// lengths that are too short or too long must not crash the probe
+			conn.Write([]byte{'E', 0, 0, 0, 2})
+		case 2:
+			conn.Write([]byte{'E', 0x7f, 0xff, 0xff, 0xff})
+		case 3:
+			msg := "SFATAL\x00C57P03\x00Mthe database system is starting up\x00\x00"
+			conn.Write(append([]byte{'E', 0, 0, 0, byte(4 + len(msg))}, msg...))
+		default:
+			conn.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 3})
+		}
+	})
+	defer stop()
+	if err := instance.WaitReadyWithOptions(context.Background(), fastWait); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+}
+
+func TestWaitReadyMySQL(t *testing.T) {
+	instance, stop := probeServer(t, "mysql", func(n int, conn net.Conn) {
+		conn.Write([]byte{10, 0, 0, 0, 10, '5', '.', '7', 0, 0})
+	})
+	defer stop()
+	if err := instance.WaitReadyWithOptions(context.Background(), fastWait); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+}
+
+func TestWaitReadyMongoDB(t *testing.T) {
+	instance, stop := probeServer(t, "mongodb", func(n int, conn net.Conn) {
+		header := make([]byte, 16)
+		io.ReadFull(conn, header)
+		io.ReadFull(conn, make([]byte, int(header[0])-16))
+		conn.Write([]byte{16, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0})
+	})
+	defer stop()
+	if err := instance.WaitReadyWithOptions(context.Background(), fastWait); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	instance, stop := probeServer(t, "redis", func(n int, conn net.Conn) {
+		conn.Write([]byte("-LOADING Redis is loading the dataset in memory\r\n"))
+	})
+	defer stop()
+	opts := WaitOptions{Timeout: 100 * time.Millisecond, Interval: 10 * time.Millisecond}
+	err := instance.WaitReadyWithOptions(context.Background(), opts)
+	if err == nil {
+		t.Fatal("WaitReady did not time out")
+	}
+	if err.Error() == context.DeadlineExceeded.Error() {
+		t.Error("timeout error does not include last probe error:", err)
+	}
+}
+
+func TestWaitReadyPollsStatus(t *testing.T) {
+	instance, stop := probeServer(t, "unknown", func(n int, conn net.Conn) {})
+	defer stop()
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		status := "starting"
+		if polls > 2 {
+			status = "running"
+		}
+		w.Write([]byte(`{"id": "someId", "host": "127.0.0.1", "port": ` +
+			strconv.Itoa(instance.Port) + `, "type": "unknown", "status": "` + status + `"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance.client = *client
+	instance.Status = "starting"
+	if err := instance.WaitReadyWithOptions(context.Background(), fastWait); err != nil {
+		t.Error("WaitReady returned an error:", err)
+	}
+	if polls != 3 {
+		t.Error("wrong number of status polls:", polls)
+	}
+}
+
+func TestWaitReadyFailed(t *testing.T) {
+	instance := &Instance{ID: "someId", Status: "failed"}
+	if err := instance.WaitReady(context.Background()); err != ErrInstanceFailed {
+		t.Error("WaitReady did not report failed instance:", err)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of events in a recording.
+const (
+	EventOutput = "o" // data read from the shell
+	EventInput  = "i" // data written to the shell
+	EventResize = "r" // terminal resized, with data "COLSxROWS"
+)
+
+// RecordHeader is the header of an asciicast v2 recording.
+type RecordHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// RecordEvent is a single event in an asciicast v2 recording.
+type RecordEvent struct {
+	Time time.Duration // time since the start of the recording
+	Type string        // EventOutput, EventInput or EventResize
+	Data string
+}
+
+// MarshalJSON encodes the event as a [time, type, data] array.
+func (e RecordEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{
+		json.Number(strconv.FormatFloat(e.Time.Seconds(), 'f', 6, 64)),
+		e.Type,
+		e.Data,
+	})
+}
+
+// UnmarshalJSON decodes an event from a [time, type, data] array.
+func (e *RecordEvent) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("asciicast event has %d fields, not 3", len(fields))
+	}
+	var secs float64
+	if err := json.Unmarshal(fields[0], &secs); err != nil {
+		return err
+	}
+	e.Time = time.Duration(math.Round(secs * float64(time.Second)))
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Recording is a shell session recorded by a Recorder.
+type Recording struct {
+	Header RecordHeader
+	Events []RecordEvent
+}
+
+// ReadRecording reads an asciicast v2 recording from r.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	rec := &Recording{}
+	if err := dec.Decode(&rec.Header); err != nil {
+		return nil, err
+	}
+	if rec.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version: %d", rec.Header.Version)
+	}
+	for {
+		var e RecordEvent
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.Events = append(rec.Events, e)
+	}
+}
+
+// Recorder writes the input and output of shell sessions to an asciicast v2
+// file, which can be played back with asciinema. Wrap a session with
+// RecordTTY or RecordStdio to record it. A Recorder is safe for concurrent
+// use.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+	// partial UTF-8 sequences left over from the last write to each stream
+	pending map[*recordStream][]byte
+}
+
+// recordStream identifies one of the streams feeding a Recorder.
+type recordStream struct {
+	typ string
+}
+
+// NewRecorder writes the header of a new recording to w and returns a
+// Recorder that writes events to it. The Version of the header is always 2,
+// its size defaults to 80x24 and its Timestamp to the current time.
+func NewRecorder(w io.Writer, header RecordHeader) (*Recorder, error) {
+	header.Version = 2
+	if header.Width == 0 || header.Height == 0 {
+		header.Width, header.Height = 80, 24
+	}
+	start := time.Now()
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, start: start, pending: map[*recordStream][]byte{}}, nil
+}
+
+// Err returns the first error writing the recording, if any. Errors writing
+// the recording do not interrupt the recorded session.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close writes out any incomplete UTF-8 sequences left over by the
+// recorded streams and returns Err. It does not close the underlying writer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s, p := range r.pending {
+		r.event(s.typ, string(p))
+		delete(r.pending, s)
+	}
+	return r.err
+}
+
+// record adds the event of data p arriving on stream s. Data is only
+// recorded up to the last complete UTF-8 sequence, since asciicast events
+// carry text; the rest is held until more data arrives.
+func (r *Recorder) record(s *recordStream, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.pending[s], p...)
+	n := utf8Complete(data)
+	if n < len(data) {
+		r.pending[s] = append([]byte(nil), data[n:]...)
+	} else {
+		delete(r.pending, s)
+	}
+	if n > 0 {
+		r.event(s.typ, string(data[:n]))
+	}
+}
+
+// event writes a single event, with r.mu held.
+func (r *Recorder) event(typ, data string) {
+	if r.err != nil {
+		return
+	}
+	line, err := json.Marshal(RecordEvent{Time: time.Since(r.start), Type: typ, Data: data})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	r.err = err
+}
+
+// utf8Complete returns the length of p without any incomplete UTF-8 sequence
+// at its end.
+func utf8Complete(p []byte) int {
+	// a sequence is at most utf8.UTFMax bytes, so only the start of the
+	// last one needs to be found
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if utf8.FullRune(p[i:]) {
+			return len(p)
+		}
+		return i
+	}
+	return len(p)
+}
+
+// resizer is implemented by sessions with a resizable terminal, like
+// *TTYSession.
+type resizer interface {
+	Resize(rows, cols int) error
+}
+
+// RecordedTTY is a terminal session that is being recorded.
+type RecordedTTY struct {
+	rwc    io.ReadWriteCloser
+	r      *Recorder
+	input  recordStream
+	output recordStream
+}
+
+// RecordTTY returns a wrapper around rwc, a session returned by Attach or
+// AttachTTY, that records everything read from it as output and everything
+// written to it as input.
+func (r *Recorder) RecordTTY(rwc io.ReadWriteCloser) *RecordedTTY {
+	return &RecordedTTY{
+		rwc:    rwc,
+		r:      r,
+		input:  recordStream{typ: EventInput},
+		output: recordStream{typ: EventOutput},
+	}
+}
+
+// Read reads terminal output from the session.
+func (t *RecordedTTY) Read(p []byte) (int, error) {
+	n, err := t.rwc.Read(p)
+	if n > 0 {
+		t.r.record(&t.output, p[:n])
+	}
+	return n, err
+}
+
+// Write sends terminal input to the session.
+func (t *RecordedTTY) Write(p []byte) (int, error) {
+	n, err := t.rwc.Write(p)
+	if n > 0 {
+		t.r.record(&t.input, p[:n])
+	}
+	return n, err
+}
+
+// Resize resizes the session's terminal and records a resize event. It
+// fails if the session's terminal can not be resized.
+func (t *RecordedTTY) Resize(rows, cols int) error {
+	rs, ok := t.rwc.(resizer)
+	if !ok {
+		return errors.New("session does not support resizing")
+	}
+	if err := rs.Resize(rows, cols); err != nil {
+		return err
+	}
+	t.r.mu.Lock()
+	t.r.event(EventResize, fmt.Sprintf("%dx%d", cols, rows))
+	t.r.mu.Unlock()
+	return nil
+}
+
+// Close closes the session.
+func (t *RecordedTTY) Close() error {
+	return t.rwc.Close()
+}
+
+// RecordStdio wraps the streams of a session returned by AttachStdio so that
+// everything written to stdin is recorded as input and everything read from
+// stdout and stderr as output. To record a StdioSession, replace its streams
+// with the wrapped ones.
+func (r *Recorder) RecordStdio(stdin io.WriteCloser, stdout, stderr io.Reader) (io.WriteCloser, io.Reader, io.Reader) {
+	return &recordedWriter{stdin, r, recordStream{typ: EventInput}},
+		&recordedReader{stdout, r, recordStream{typ: EventOutput}},
+		&recordedReader{stderr, r, recordStream{typ: EventOutput}}
+}
+
+type recordedWriter struct {
+	io.WriteCloser
+	r *Recorder
+	s recordStream
+}
+
+func (w *recordedWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if n > 0 {
+		w.r.record(&w.s, p[:n])
+	}
+	return n, err
+}
+
+type recordedReader struct {
+	io.Reader
+	r *Recorder
+	s recordStream
+}
+
+func (rr *recordedReader) Read(p []byte) (int, error) {
+	n, err := rr.Reader.Read(p)
+	if n > 0 {
+		rr.r.record(&rr.s, p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,127 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTTY is a session that echoes its input and can be resized.
+type fakeTTY struct {
+	bytes.Buffer
+	rows, cols int
+}
+
+func (f *fakeTTY) Close() error { return nil }
+
+func (f *fakeTTY) Resize(rows, cols int) error {
+	f.rows, f.cols = rows, cols
+	return nil
+}
+
+func TestRecordTTY(t *testing.T) {
+	var file bytes.Buffer
+	rec, err := NewRecorder(&file, RecordHeader{Title: "redis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty := &fakeTTY{}
+	session := rec.RecordTTY(tty)
+	io.WriteString(session, "scan 0\r\n")
+	buf := make([]byte, 64)
+	n, _ := session.Read(buf)
+	if string(buf[:n]) != "scan 0\r\n" {
+		t.Errorf("wrong output: %q", buf[:n])
+	}
+	// a multi-byte character split across writes is recorded whole
+	session.Write([]byte("caf\xc3"))
+	session.Write([]byte("\xa9"))
+	if err := session.Resize(50, 132); err != nil || tty.rows != 50 || tty.cols != 132 {
+		t.Errorf("session not resized: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := ReadRecording(&file)
+	if err != nil {
+		t.Fatal("ReadRecording returned an error:", err)
+	}
+	h := recording.Header
+	if h.Version != 2 || h.Width != 80 || h.Height != 24 || h.Title != "redis" || h.Timestamp == 0 {
+		t.Errorf("wrong header: %+v", h)
+	}
+	expected := []RecordEvent{
+		{Type: EventInput, Data: "scan 0\r\n"},
+		{Type: EventOutput, Data: "scan 0\r\n"},
+		{Type: EventInput, Data: "caf"},
+		{Type: EventInput, Data: "é"},
+		{Type: EventResize, Data: "132x50"},
+	}
+	if len(recording.Events) != len(expected) {
+		t.Fatalf("wrong events: %+v", recording.Events)
+	}
+	var last time.Duration
+	for i, e := range recording.Events {
+		if e.Type != expected[i].Type || e.Data != expected[i].Data {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], e)
+		}
+		if e.Time < last {
+			t.Errorf("event %d went back in time: %s", i, e.Time)
+		}
+		last = e.Time
+	}
+}
+
+func TestRecordStdio(t *testing.T) {
+	var file bytes.Buffer
+	rec, _ := NewRecorder(&file, RecordHeader{Width: 100, Height: 30})
+	stdinR, stdinW := io.Pipe()
+	stdin, stdout, stderr := rec.RecordStdio(stdinW, strings.NewReader("OK\n"), strings.NewReader("warning\n"))
+	go ioutil.ReadAll(stdinR)
+	io.WriteString(stdin, "ping\n")
+	stdin.Close()
+	ioutil.ReadAll(stdout)
+	ioutil.ReadAll(stderr)
+	recording, err := ReadRecording(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Header.Width != 100 || recording.Header.Height != 30 {
+		t.Errorf("wrong size: %+v", recording.Header)
+	}
+	var got []string
+	for _, e := range recording.Events {
+		got = append(got, e.Type+" "+e.Data)
+	}
+	if strings.Join(got, "") != "i ping\no OK\no warning\n" {
+		t.Errorf("wrong events: %q", got)
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	file := `{"version": 2, "width": 80, "height": 24}
+[0.25, "o", "$ "]
+[1.5, "i", "ls\r"]
+`
+	recording, err := ReadRecording(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Events) != 2 || recording.Events[1].Time != 1500*time.Millisecond || recording.Events[1].Data != "ls\r" {
+		t.Errorf("wrong events: %+v", recording.Events)
+	}
+	if _, err := ReadRecording(strings.NewReader(`{"version": 1}`)); err == nil {
+		t.Error("ReadRecording accepted asciicast v1")
+	}
+	if _, err := ReadRecording(strings.NewReader(file + `[1, "o"]`)); err == nil {
+		t.Error("ReadRecording accepted a malformed event")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how a Client retries API requests that fail because of
+// transient network or server errors.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a request,
+	// including the first one. A value of 1 or less disables retries.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. Each subsequent retry
+	// doubles the delay, up to MaxBackoff.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between attempts, unless the server
+	// asks for a longer one using a Retry-After header.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by clients created by NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  250 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+}
+
+// WithRetryPolicy sets the RetryPolicy used for API requests.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// Attempt records the outcome of a single failed attempt at an API request.
+type Attempt struct {
+	Err   error         // the error the attempt failed with
+	Delay time.Duration // how long was waited before the next attempt
+}
+
+// RetryError is returned when an API request has failed after being attempted
+// more than once. Err is the error from the final attempt.
+type RetryError struct {
+	Attempts []Attempt
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s (gave up after %d attempts)", e.Err, len(e.Attempts))
+}
+
+// Unwrap returns the error from the final attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// backoff returns how long to wait before making the given attempt, which
+// must be at least 2.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 2; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// jitter between half and all of the computed delay so that many clients
+	// failing at once don't all retry in lockstep.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable reports whether a request that failed with err should be tried
+// again. Requests that are not idempotent are only retried when it is certain
+// the server did not act on them.
+func retryable(err error, idempotent bool) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return true
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return idempotent
+		}
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial" || idempotent
+	}
+	return idempotent && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+// retryAfter parses the value of a Retry-After header, which may either be a
+// number of seconds or an HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// withRetries calls do until it succeeds, it fails with an error that is not
+// worth retrying, the policy's attempts are exhausted or ctx is done.
+func (p RetryPolicy) withRetries(ctx context.Context, idempotent bool, do func() error) error {
+	var attempts []Attempt
+	for {
+		err := do()
+		if err == nil {
+			return nil
+		}
+		attempt := Attempt{Err: err}
+		attempts = append(attempts, attempt)
+		if ctx.Err() != nil || len(attempts) >= p.MaxAttempts || !retryable(err, idempotent) {
+			break
+		}
+		delay := p.backoff(len(attempts) + 1)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		attempts[len(attempts)-1].Delay = delay
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			attempts = append(attempts, Attempt{Err: ctx.Err()})
+		case <-timer.C:
+			continue
+		}
+		break
+	}
+	last := attempts[len(attempts)-1].Err
+	if len(attempts) == 1 {
+		return last
+	}
+	return &RetryError{Attempts: attempts, Err: last}
+}
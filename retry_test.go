@@ -0,0 +1,108 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var fastRetries = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  10 * time.Millisecond,
+}
+
+func flakyServer(failures int, status int, header http.Header) (*httptest.Server, *int) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": "try again"}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	return ts, &requests
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	ts, requests := flakyServer(2, 503, nil)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(fastRetries))
+	if _, err := client.List(); err != nil {
+		t.Error("client.List was not retried:", err)
+	}
+	if *requests != 3 {
+		t.Error("wrong number of requests:", *requests)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	ts, requests := flakyServer(5, 502, nil)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(fastRetries))
+	err := client.Destroy("12345678")
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatal("client.Destroy did not return a RetryError:", err)
+	}
+	if len(retryErr.Attempts) != 3 || *requests != 3 {
+		t.Error("wrong number of attempts:", len(retryErr.Attempts), *requests)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 502 {
+		t.Error("RetryError does not unwrap to final APIError:", err)
+	}
+}
+
+func TestRetryPostUnsafe(t *testing.T) {
+	ts, requests := flakyServer(1, 503, nil)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(fastRetries))
+	if _, err := client.rawRequest(context.Background(), "POST", "/new/redis", nil, nil); err == nil {
+		t.Error("POST without idempotency key should not retry after a 503")
+	}
+	if *requests != 1 {
+		t.Error("wrong number of requests:", *requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	ts, requests := flakyServer(1, 429, http.Header{"Retry-After": {"1"}})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(fastRetries))
+	start := time.Now()
+	if _, err := client.List(); err != nil {
+		t.Error("client.List was not retried:", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("Retry-After header was not honored")
+	}
+	if *requests != 2 {
+		t.Error("wrong number of requests:", *requests)
+	}
+}
+
+func TestRetryDisabled(t *testing.T) {
+	ts, requests := flakyServer(1, 503, nil)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL}, WithRetryPolicy(RetryPolicy{}))
+	if _, err := client.List(); err == nil {
+		t.Error("client.List should have failed without retries")
+	}
+	if *requests != 1 {
+		t.Error("wrong number of requests:", *requests)
+	}
+}
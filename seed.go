@@ -0,0 +1,215 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SeedResult reports the outcome of loading a single script with Seed.
+type SeedResult struct {
+	Name     string // file name of the script, or "script N"
+	ExitCode int    // exit status of the shell, or -1 if it was not reported
+	Stdout   []byte
+	Stderr   []byte
+	Err      error // nil if the script loaded successfully
+}
+
+// SeedError is returned when the instance's shell fails to load a script,
+// either exiting with a non-zero status or reporting an error on stderr.
+type SeedError struct {
+	Name     string
+	ExitCode int
+	Message  string // the first error reported on stderr, if any
+}
+
+func (e *SeedError) Error() string {
+	msg := fmt.Sprintf("seeding %s failed", e.Name)
+	if e.ExitCode > 0 {
+		msg += fmt.Sprintf(": exit status %d", e.ExitCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// seedErrorMarkers identify the lines in which the shells of the supported
+// services report errors, since some of them exit successfully regardless.
+var seedErrorMarkers = []string{"ERROR", "FATAL", "(error)", "Error:"}
+
+// redisErrorPrefixes start the error replies that redis-cli prints on
+// stdout when it is not attached to a terminal, exiting successfully.
+var redisErrorPrefixes = []string{
+	"ERR ", "WRONGTYPE ", "NOAUTH ", "NOPERM ", "OOM ", "READONLY ",
+	"EXECABORT ", "BUSYKEY ", "NOSCRIPT ", "LOADING ", "MISCONF ",
+}
+
+// Seed loads each of the scripts into the instance by streaming it through
+// the instance's shell, such as psql for postgres or redis-cli for redis,
+// in order. It stops at the first script that fails, returning the results
+// of the scripts it ran along with a *SeedError. Scripts that have a Name
+// method, like *os.File, are reported by that name.
+func (i *Instance) Seed(ctx context.Context, scripts ...io.Reader) ([]SeedResult, error) {
+	results := make([]SeedResult, 0, len(scripts))
+	for n, script := range scripts {
+		name := fmt.Sprintf("script %d", n+1)
+		if named, ok := script.(interface{ Name() string }); ok {
+			name = named.Name()
+		}
+		result := i.seed(ctx, name, i.seedCmd(), script)
+		results = append(results, result)
+		if result.Err != nil {
+			return results, result.Err
+		}
+	}
+	return results, nil
+}
+
+// SeedFiles is like Seed, but loads the scripts from the named files. Each
+// path may also be a directory, whose files are loaded in lexical order, or
+// a glob pattern as accepted by filepath.Match, whose matches are loaded in
+// lexical order. This suits directories of migrations named like
+// 001_schema.sql, 002_data.sql.
+func (i *Instance) SeedFiles(ctx context.Context, paths ...string) ([]SeedResult, error) {
+	var files []string
+	for _, path := range paths {
+		matches, err := seedPaths(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	results := make([]SeedResult, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return results, err
+		}
+		result := i.seed(ctx, file, i.seedCmd(), f)
+		f.Close()
+		results = append(results, result)
+		if result.Err != nil {
+			return results, result.Err
+		}
+	}
+	return results, nil
+}
+
+// seedPaths expands a path given to SeedFiles into the files to load.
+func seedPaths(path string) ([]string, error) {
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no seed files match %s", path)
+	}
+	sort.Strings(matches)
+	var files []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, match)
+			continue
+		}
+		entries, err := ioutil.ReadDir(match)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() {
+				files = append(files, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// seed runs a single script through the command cmd in the instance's
+// remote shell.
+func (i *Instance) seed(ctx context.Context, name string, cmd []string, script io.Reader) SeedResult {
+	result := SeedResult{Name: name, ExitCode: -1}
+	res, err := i.Exec(ctx, cmd, script)
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+	case errors.Is(err, ErrNoExitStatus):
+		// older servers don't report the exit status, so rely on stderr
+	default:
+		result.Err = err
+		return result
+	}
+	if res != nil {
+		result.Stdout, result.Stderr = res.Stdout, res.Stderr
+		if err == nil || exitErr != nil {
+			result.ExitCode = res.ExitCode
+		}
+	}
+	message := seedErrorLine(result.Stderr)
+	if message == "" && i.Type == "redis" {
+		message = redisErrorLine(result.Stdout)
+		if message == "" {
+			message = redisErrorLine(result.Stderr)
+		}
+	}
+	if result.ExitCode > 0 || message != "" {
+		result.Err = &SeedError{Name: name, ExitCode: result.ExitCode, Message: message}
+	}
+	return result
+}
+
+// seedCmd returns the command used to load scripts, which is the instance's
+// shell set to stop at the first error where that's not the default.
+func (i *Instance) seedCmd() []string {
+	cmd := i.ContainerShell
+	if len(cmd) > 0 && i.Type == "postgres" {
+		cmd = append(cmd[:len(cmd):len(cmd)], "-v", "ON_ERROR_STOP=1")
+	}
+	return cmd
+}
+
+// seedErrorLine returns the first line of stderr that reports an error.
+func seedErrorLine(stderr []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for _, marker := range seedErrorMarkers {
+			if strings.Contains(line, marker) {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// redisErrorLine returns the first error reply in the output of redis-cli,
+// or of scripts that run it.
+func redisErrorLine(stdout []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for _, prefix := range redisErrorPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		}
+	}
+	return ""
+}
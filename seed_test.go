@@ -0,0 +1,145 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mktmpio/go-mktmpio"
+)
+
+// seedShell is a fake psql that fails on scripts containing "fail", reports
+// errors without failing on scripts containing "warn" and prints notices for
+// scripts containing "notice". Like redis-cli, it prints an error reply on
+// stdout and exits successfully for scripts containing "wrongtype".
+func seedShell(run shellRun, stdout, stderr io.Writer) int {
+	switch {
+	case strings.Contains(run.stdin, "fail"):
+		io.WriteString(stderr, "psql:<stdin>:1: ERROR:  syntax error at or near \"fail\"\n")
+		return 3
+	case strings.Contains(run.stdin, "warn"):
+		io.WriteString(stderr, "psql:<stdin>:1: ERROR:  relation \"t\" already exists\n")
+	case strings.Contains(run.stdin, "wrongtype"):
+		io.WriteString(stdout, "OK\nWRONGTYPE Operation against a key holding the wrong kind of value\n")
+		return 0
+	case strings.Contains(run.stdin, "notice"):
+		io.WriteString(stderr, "psql:<stdin>:1: NOTICE:  table \"t\" does not exist, skipping\n")
+	}
+	io.WriteString(stdout, "CREATE TABLE\n")
+	return 0
+}
+
+// seedInstance starts a Server running seedShell and creates an instance
+// of the given service on it.
+func seedInstance(t *testing.T, service string) (*mktmpio.Instance, *shellLog) {
+	s, log := shellServer(t, seedShell)
+	instance, err := s.Client().Create(service)
+	if err != nil {
+		t.Fatal("Create returned an error:", err)
+	}
+	return instance, log
+}
+
+// scripts returns the scripts run by the sessions in log.
+func scripts(log *shellLog) []string {
+	var scripts []string
+	for _, run := range log.all() {
+		scripts = append(scripts, run.stdin)
+	}
+	return scripts
+}
+
+func TestSeed(t *testing.T) {
+	instance, log := seedInstance(t, "postgres")
+	instance.ContainerShell = []string{"psql", "-U", "mktmpio"}
+	results, err := instance.Seed(context.Background(),
+		strings.NewReader("create table t;"),
+		strings.NewReader("drop table if exists notice;"),
+		strings.NewReader("fail;"),
+		strings.NewReader("never run;"))
+	var seedErr *mktmpio.SeedError
+	if !errors.As(err, &seedErr) || seedErr.Name != "script 3" || seedErr.ExitCode != 3 {
+		t.Fatalf("expected SeedError for script 3, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("error does not include stderr: %v", err)
+	}
+	if len(results) != 3 || results[0].Err != nil || results[1].Err != nil || results[2].Err != err {
+		t.Errorf("wrong results: %+v", results)
+	}
+	if string(results[0].Stdout) != "CREATE TABLE\n" || results[1].ExitCode != 0 {
+		t.Errorf("wrong result: %+v", results[0])
+	}
+	if ran := scripts(log); len(ran) != 3 {
+		t.Errorf("scripts ran after a failure: %q", ran)
+	}
+	if args := log.last().args; strings.Join(args, " ") != "psql -U mktmpio -v ON_ERROR_STOP=1" {
+		t.Errorf("wrong command: %q", args)
+	}
+	if len(instance.ContainerShell) != 3 {
+		t.Errorf("ContainerShell modified: %q", instance.ContainerShell)
+	}
+
+	_, err = instance.Seed(context.Background(), strings.NewReader("warn;"))
+	if !errors.As(err, &seedErr) || seedErr.ExitCode != 0 || !strings.Contains(seedErr.Message, "already exists") {
+		t.Errorf("error on stderr not reported: %v", err)
+	}
+}
+
+func TestSeedRedis(t *testing.T) {
+	instance, _ := seedInstance(t, "redis")
+	if _, err := instance.Seed(context.Background(), strings.NewReader("SET k v\n")); err != nil {
+		t.Error("Seed returned an error:", err)
+	}
+	_, err := instance.Seed(context.Background(), strings.NewReader("SET wrongtype v\nLPUSH wrongtype x\n"))
+	var seedErr *mktmpio.SeedError
+	if !errors.As(err, &seedErr) || !strings.HasPrefix(seedErr.Message, "WRONGTYPE") {
+		t.Errorf("error reply not reported: %v", err)
+	}
+}
+
+func TestSeedFiles(t *testing.T) {
+	instance, log := seedInstance(t, "postgres")
+	dir := t.TempDir()
+	files := map[string]string{
+		"migrations/002_data.sql":   "insert",
+		"migrations/001_schema.sql": "create",
+		"migrations/010_index.sql":  "index",
+		"fixtures/b.sql":            "fixture b",
+		"fixtures/a.sql":            "fixture a",
+		"fixtures/readme.txt":       "not sql",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "migrations", "old"), 0755)
+
+	results, err := instance.SeedFiles(context.Background(),
+		filepath.Join(dir, "migrations"),
+		filepath.Join(dir, "fixtures", "*.sql"))
+	if err != nil {
+		t.Fatal("SeedFiles returned an error:", err)
+	}
+	expected := "create,insert,index,fixture a,fixture b"
+	if ran := scripts(log); strings.Join(ran, ",") != expected {
+		t.Errorf("expected scripts %s, got %q", expected, ran)
+	}
+	if len(results) != 5 || results[0].Name != filepath.Join(dir, "migrations", "001_schema.sql") {
+		t.Errorf("wrong results: %+v", results)
+	}
+
+	if _, err := instance.SeedFiles(context.Background(), filepath.Join(dir, "*.nope")); err == nil {
+		t.Error("SeedFiles accepted a pattern without matches")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio_test
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/mktmpio/go-mktmpio/mktmpiotest"
+)
+
+// shellRun is a shell session run on a shellServer.
+type shellRun struct {
+	args  []string
+	stdin string
+}
+
+// shellLog records the sessions run on a shellServer.
+type shellLog struct {
+	mu   sync.Mutex
+	runs []shellRun
+}
+
+func (l *shellLog) all() []shellRun {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]shellRun(nil), l.runs...)
+}
+
+func (l *shellLog) last() shellRun {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.runs) == 0 {
+		return shellRun{}
+	}
+	return l.runs[len(l.runs)-1]
+}
+
+// shellServer starts a Server whose shell reads all of stdin and then runs
+// fn, exiting with the status it returns. The sessions are recorded in the
+// returned log.
+func shellServer(t *testing.T, fn func(run shellRun, stdout, stderr io.Writer) int) (*mktmpiotest.Server, *shellLog) {
+	s := mktmpiotest.NewServer()
+	t.Cleanup(s.Close)
+	log := &shellLog{}
+	s.Shell = func(sh *mktmpiotest.Shell) int {
+		stdin, _ := ioutil.ReadAll(sh.Stdin)
+		run := shellRun{args: sh.Args, stdin: string(stdin)}
+		log.mu.Lock()
+		log.runs = append(log.runs, run)
+		log.mu.Unlock()
+		return fn(run, sh.Stdout, sh.Stderr)
+	}
+	return s, log
+}
@@ -0,0 +1,88 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Snapshot is a saved copy of an instance's data, from which any number of
+// new instances can be created with CreateFromSnapshot.
+type Snapshot struct {
+	ID   string
+	Name string
+	// InstanceID is the ID of the instance the snapshot was taken of, which
+	// may since have been destroyed.
+	InstanceID string
+	Type       string
+	Version    string
+	CreatedAt  time.Time
+}
+
+// snapshotParams is the JSON request body sent when taking a snapshot.
+type snapshotParams struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Snapshot takes a snapshot named `name` of the instance identified by `id`.
+// The instance keeps running and can be modified or destroyed without
+// affecting the snapshot.
+func (c Client) Snapshot(id, name string) (*Snapshot, error) {
+	return c.SnapshotContext(context.Background(), id, name)
+}
+
+// SnapshotContext is like Snapshot but aborts the request if ctx is done
+// before the server has responded.
+func (c Client) SnapshotContext(ctx context.Context, id, name string) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	path := "/i/" + id + "/snapshots"
+	if err := c.jsonRequest(ctx, "POST", path, nil, snapshotParams{Name: name}, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots retrieves the snapshots that have been taken with the
+// Client's account.
+func (c Client) ListSnapshots() ([]Snapshot, error) {
+	return c.ListSnapshotsContext(context.Background())
+}
+
+// ListSnapshotsContext is like ListSnapshots but aborts the request if ctx
+// is done before the server has responded.
+func (c Client) ListSnapshotsContext(ctx context.Context) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	if err := c.jsonRequest(ctx, "GET", "/s", nil, nil, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// CreateFromSnapshot creates a server with a copy of the data in the
+// snapshot identified by `snapshotID`. The new instance runs the same
+// service and version as the instance the snapshot was taken of, and its
+// SourceSnapshot is set to snapshotID.
+func (c Client) CreateFromSnapshot(snapshotID string) (*Instance, error) {
+	return c.CreateFromSnapshotContext(context.Background(), snapshotID)
+}
+
+// CreateFromSnapshotContext is like CreateFromSnapshot but aborts the
+// request if ctx is done before the server has responded.
+func (c Client) CreateFromSnapshotContext(ctx context.Context, snapshotID string) (*Instance, error) {
+	return c.CreateFromSnapshotWithOptionsContext(ctx, snapshotID, CreateOptions{})
+}
+
+// CreateFromSnapshotWithOptionsContext is like CreateFromSnapshotContext but
+// allows specifying the size, lifetime and other settings of the new
+// instance. Since the service and version are those of the snapshot,
+// opts.Version must be empty.
+func (c Client) CreateFromSnapshotWithOptionsContext(ctx context.Context, snapshotID string, opts CreateOptions) (*Instance, error) {
+	if opts.Version != "" {
+		return nil, errors.New("cannot set the version of an instance created from a snapshot")
+	}
+	return c.create(ctx, "/s/"+snapshotID+"/new", opts)
+}
@@ -0,0 +1,83 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	var path, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		path, body = r.Method+" "+r.URL.Path, string(b)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"abcd","name":"golden","instanceId":"1234","type":"postgres","createdAt":"2017-03-01T12:00:00Z"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	snapshot, err := client.Snapshot("1234", "golden")
+	if err != nil {
+		t.Fatal("Snapshot returned an error:", err)
+	}
+	if path != "POST /i/1234/snapshots" || body != `{"name":"golden"}` {
+		t.Errorf("wrong request: %s %s", path, body)
+	}
+	want := Snapshot{ID: "abcd", Name: "golden", InstanceID: "1234", Type: "postgres",
+		CreatedAt: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)}
+	if *snapshot != want {
+		t.Errorf("wrong snapshot: %+v", snapshot)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	ts := server(t, 200, `[{"id":"abcd","name":"golden"},{"id":"ef01"}]`)
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	snapshots, err := client.ListSnapshots()
+	if err != nil {
+		t.Fatal("ListSnapshots returned an error:", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "golden" || snapshots[1].ID != "ef01" {
+		t.Errorf("wrong snapshots: %+v", snapshots)
+	}
+}
+
+func TestCreateFromSnapshot(t *testing.T) {
+	var path string
+	var params createParams
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		json.NewDecoder(r.Body).Decode(&params)
+		if r.Header.Get(idempotencyKeyHeader) == "" {
+			t.Error("no idempotency key sent")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"5678","type":"postgres","sourceSnapshot":"abcd"}`))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	instance, err := client.CreateFromSnapshotWithOptionsContext(context.Background(), "abcd", CreateOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal("CreateFromSnapshot returned an error:", err)
+	}
+	if path != "POST /s/abcd/new" || params.TTL != 3600 {
+		t.Errorf("wrong request: %s %+v", path, params)
+	}
+	if instance.SourceSnapshot != "abcd" || instance.Type != "postgres" {
+		t.Errorf("wrong instance: %+v", instance)
+	}
+	if _, err := client.CreateFromSnapshotWithOptionsContext(context.Background(), "abcd", CreateOptions{Version: "9.6"}); err == nil {
+		t.Error("CreateFromSnapshot with a version did not fail")
+	}
+}
@@ -24,6 +24,16 @@ const (
 	Stdout
 	// Stderr represents standard error steam type.
 	Stderr
+	// Exit carries the exit status of a remote command as a 4 byte
+	// big-endian integer. It is sent once, after all other output.
+	Exit
+	// CloseStdin is an empty frame marking the end of standard input. It
+	// is sent once, after the last Stdin frame.
+	CloseStdin
+
+	// NoExitStatus is the status reported by StdCopyExit if the stream did
+	// not contain an Exit frame.
+	NoExitStatus = -1
 
 	stdWriterPrefixLen = 8
 	stdWriterFdIndex   = 0
@@ -82,6 +92,38 @@ func NewStdWriter(w io.Writer, t StdType) io.Writer {
 	}
 }
 
+// WriteExitStatus writes an Exit frame containing the given status to w.
+func WriteExitStatus(w io.Writer, status int) error {
+	frame := [stdWriterPrefixLen + 4]byte{stdWriterFdIndex: byte(Exit)}
+	binary.BigEndian.PutUint32(frame[stdWriterSizeIndex:], 4)
+	binary.BigEndian.PutUint32(frame[stdWriterPrefixLen:], uint32(int32(status)))
+	_, err := w.Write(frame[:])
+	return err
+}
+
+// WriteCloseStdin writes a CloseStdin frame to w.
+func WriteCloseStdin(w io.Writer) error {
+	frame := [stdWriterPrefixLen]byte{stdWriterFdIndex: byte(CloseStdin)}
+	_, err := w.Write(frame[:])
+	return err
+}
+
+// StdinCopy copies the payload of the Stdin frames in `src` to `dst` until it
+// reads a CloseStdin frame or hits EOF, in which case it returns a nil error.
+// Any other kind of frame is an error. Since src is read in large chunks,
+// data following the CloseStdin frame may have been consumed.
+func StdinCopy(dst io.Writer, src io.Reader) (written int64, err error) {
+	return stdCopy(dst, nil, nil, src, nil, true)
+}
+
+// StdCopyExit is like StdCopy, but also returns the exit status sent in the
+// stream's Exit frame, or NoExitStatus if there was none.
+func StdCopyExit(dstout, dsterr io.Writer, src io.Reader) (written int64, status int, err error) {
+	status = NoExitStatus
+	written, err = stdCopy(dstout, dstout, dsterr, src, &status, false)
+	return
+}
+
 // StdCopy is a modified version of io.Copy.
 //
 // StdCopy will demultiplex `src`, assuming that it contains two streams,
@@ -92,7 +134,17 @@ func NewStdWriter(w io.Writer, t StdType) io.Writer {
 // In other words: if `err` is non nil, it indicates a real underlying error.
 //
 // `written` will hold the total number of bytes written to `dstout` and `dsterr`.
+//
+// Exit frames are skipped; use StdCopyExit to retrieve the exit status.
+// CloseStdin frames are skipped too.
 func StdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error) {
+	return stdCopy(dstout, dstout, dsterr, src, nil, false)
+}
+
+// stdCopy demultiplexes src, writing each kind of frame to its own writer.
+// A nil writer makes frames of that kind an error. If stopAtClose is set,
+// a CloseStdin frame ends the copy, otherwise it is skipped.
+func stdCopy(dstin, dstout, dsterr io.Writer, src io.Reader, status *int, stopAtClose bool) (written int64, err error) {
 	var (
 		buf       = make([]byte, startingBufLen)
 		bufLen    = len(buf)
@@ -120,18 +172,29 @@ func StdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error)
 		}
 
 		// Check the first byte to know where to write
-		switch StdType(buf[stdWriterFdIndex]) {
+		frameType := StdType(buf[stdWriterFdIndex])
+		switch frameType {
 		case Stdin:
-			fallthrough
+			out = dstin
 		case Stdout:
 			// Write on stdout
 			out = dstout
 		case Stderr:
 			// Write on stderr
 			out = dsterr
+		case Exit:
+			out = nil
+		case CloseStdin:
+			if stopAtClose {
+				return written, nil
+			}
+			out = nil
 		default:
 			return 0, fmt.Errorf("Unrecognized input header: %d", buf[stdWriterFdIndex])
 		}
+		if out == nil && frameType <= Stderr {
+			return 0, fmt.Errorf("Unexpected input header: %d", buf[stdWriterFdIndex])
+		}
 
 		// Retrieve the size of the frame
 		frameSize = int(binary.BigEndian.Uint32(buf[stdWriterSizeIndex : stdWriterSizeIndex+4]))
@@ -159,6 +222,16 @@ func StdCopy(dstout, dsterr io.Writer, src io.Reader) (written int64, err error)
 			}
 		}
 
+		if out == nil {
+			// Control frame, record the exit status instead of writing it out
+			if frameType == Exit && status != nil && frameSize >= 4 {
+				*status = int(int32(binary.BigEndian.Uint32(buf[stdWriterPrefixLen:])))
+			}
+			copy(buf, buf[frameSize+stdWriterPrefixLen:])
+			nr -= frameSize + stdWriterPrefixLen
+			continue
+		}
+
 		// Write the retrieved frame (without header)
 		nw, ew = out.Write(buf[stdWriterPrefixLen : frameSize+stdWriterPrefixLen])
 		if ew != nil {
@@ -261,3 +261,80 @@ func BenchmarkWrite(b *testing.B) {
 		}
 	}
 }
+
+func TestStdCopyExit(t *testing.T) {
+	buffer, err := getSrcBuffer([]byte("out"), []byte("err"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteExitStatus(buffer, 3); err != nil {
+		t.Fatal(err)
+	}
+	dstOut := new(bytes.Buffer)
+	dstErr := new(bytes.Buffer)
+	written, status, err := StdCopyExit(dstOut, dstErr, bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 6 || dstOut.String() != "out" || dstErr.String() != "err" {
+		t.Fatalf("Exit frame was written as output: %d %q %q", written, dstOut, dstErr)
+	}
+	if status != 3 {
+		t.Fatalf("Expected exit status 3, got %d", status)
+	}
+	// StdCopy skips the exit frame
+	written, err = StdCopy(ioutil.Discard, ioutil.Discard, bytes.NewReader(buffer.Bytes()))
+	if err != nil || written != 6 {
+		t.Fatalf("StdCopy did not skip exit frame: %d %v", written, err)
+	}
+}
+
+func TestStdCopyExitMissing(t *testing.T) {
+	buffer, err := getSrcBuffer([]byte("out"), []byte("err"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, status, err := StdCopyExit(ioutil.Discard, ioutil.Discard, buffer)
+	if err != nil || status != NoExitStatus {
+		t.Fatalf("Expected no exit status, got %d %v", status, err)
+	}
+}
+
+func TestStdinCopy(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	stdin := NewStdWriter(buffer, Stdin)
+	// the legacy EOF sentinel is just data in a Stdin frame
+	stdin.Write([]byte{255, 255, 255, 255})
+	stdin.Write([]byte("data"))
+	if err := WriteCloseStdin(buffer); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Write([]byte("after close"))
+	dst := new(bytes.Buffer)
+	written, err := StdinCopy(dst, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 8 || dst.String() != "\xff\xff\xff\xffdata" {
+		t.Fatalf("Wrong stdin copied: %d %q", written, dst)
+	}
+	// StdCopy skips the close frame
+	buffer.Reset()
+	NewStdWriter(buffer, Stdout).Write([]byte("out"))
+	WriteCloseStdin(buffer)
+	NewStdWriter(buffer, Stdout).Write([]byte("put"))
+	dst.Reset()
+	if _, err := StdCopy(dst, ioutil.Discard, buffer); err != nil || dst.String() != "output" {
+		t.Fatalf("StdCopy did not skip close frame: %q %v", dst, err)
+	}
+}
+
+func TestStdinCopyUnexpectedFrame(t *testing.T) {
+	buffer, err := getSrcBuffer([]byte("out"), []byte("err"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StdinCopy(ioutil.Discard, buffer); err == nil {
+		t.Fatal("StdinCopy should fail on output frames")
+	}
+}
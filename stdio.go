@@ -0,0 +1,187 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/mktmpio/go-mktmpio/stdcopy"
+	"golang.org/x/net/websocket"
+)
+
+// stdioProtocolV2 is the websocket subprotocol for version 2 of the stdio
+// protocol. With version 1, stdin is sent as raw binary frames and ends with
+// the stdinEOF sentinel, which can't be told apart from the same four bytes
+// of input. With version 2, stdin is multiplexed like the output, ending with
+// a stdcopy.CloseStdin frame, and the server reports the exit status of the
+// shell with a stdcopy.Exit frame. Only version 2 is offered, since servers
+// built on a stock websocket.Handler reject a handshake that offers more than
+// one subprotocol; servers that do not select it speak version 1.
+const stdioProtocolV2 = "mktmpio.stdio.v2"
+
+// stdinEOF is a cheap hack sentinel value to indicate EOF to the server
+// without closing the actual connection. This would be so much easier with
+// plain TCP :-(
+var stdinEOF = []byte{255, 255, 255, 255}
+
+// stdinWriter writes standard input to a stdio websocket using the
+// protocol negotiated for it.
+type stdinWriter struct {
+	conn   *websocket.Conn
+	w      io.Writer
+	framed bool
+	err    error // first error writing to conn
+}
+
+func newStdinWriter(conn *websocket.Conn) *stdinWriter {
+	if p := conn.Config().Protocol; len(p) == 1 && p[0] == stdioProtocolV2 {
+		return &stdinWriter{
+			conn:   conn,
+			w:      stdcopy.NewStdWriter(conn, stdcopy.Stdin),
+			framed: true,
+		}
+	}
+	return &stdinWriter{conn: conn, w: conn}
+}
+
+func (w *stdinWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// CloseStdin tells the server there is no more input.
+func (w *stdinWriter) CloseStdin() error {
+	var err error
+	if w.framed {
+		err = stdcopy.WriteCloseStdin(w.conn)
+	} else {
+		_, err = w.conn.Write(stdinEOF)
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// StdioSession is a remote shell with separate stdin, stdout and stderr
+// streams, as opened by AttachStdioSession.
+type StdioSession struct {
+	// Stdin is the shell's standard input. Closing it tells the shell there
+	// is no more input.
+	Stdin io.WriteCloser
+	// Stdout and Stderr are the shell's output. Once the session is over
+	// they return io.EOF if it ended cleanly, or else the session's error.
+	Stdout io.Reader
+	Stderr io.Reader
+
+	conn   *wsConn
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// AttachStdioSession creates a remote shell for the instance identified by
+// `id`, like AttachStdio, and returns it as a StdioSession. The session is
+// closed when ctx is done.
+func (c Client) AttachStdioSession(ctx context.Context, id string) (*StdioSession, error) {
+	conn, err := c.attachWS(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	errReader, errWriter := io.Pipe()
+	s := &StdioSession{
+		Stdin:  inWriter,
+		Stdout: outReader,
+		Stderr: errReader,
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
+	stop := closeOnDone(ctx, conn)
+	go func() {
+		// stdcopy is Docker's demuxer for their stdout/stderr multiplexed stream
+		_, err := stdcopy.StdCopy(outWriter, errWriter, conn)
+		stop()
+		if err != nil {
+			// the output is garbled, so hang up on the server and stop
+			// sending it stdin
+			conn.Close()
+			inReader.CloseWithError(err)
+		} else {
+			err = conn.closeErr()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		s.fail(err)
+		err = s.Err()
+		errWriter.CloseWithError(err)
+		outWriter.CloseWithError(err)
+		close(s.done)
+	}()
+	go func() {
+		stdin := newStdinWriter(conn.Conn)
+		_, err := io.Copy(stdin, inReader)
+		if err == nil {
+			err = stdin.CloseStdin()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else {
+			s.fail(stdin.err)
+		}
+		inReader.CloseWithError(err)
+	}()
+	return s, nil
+}
+
+// fail records err as the reason the session failed, unless it already
+// failed, ended or was closed.
+func (s *StdioSession) fail(err error) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && !s.closed {
+		s.err = err
+	}
+}
+
+// Wait waits for the shell's output to end and returns the session's error,
+// as Err does.
+func (s *StdioSession) Wait() error {
+	<-s.done
+	return s.Err()
+}
+
+// Err returns the error that ended the session, or nil if it is still going
+// or ended cleanly. This is either a demultiplexing error, an error writing
+// to stdin, a *CloseError if the server closed the session with an error
+// status, ErrConnectionLost if the connection dropped, or ctx.Err() if the
+// context passed to AttachStdioSession is done.
+func (s *StdioSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the session. Output streams then return io.EOF and Wait
+// returns nil, unless the session had already failed.
+func (s *StdioSession) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.conn.Close()
+}
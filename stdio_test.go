@@ -0,0 +1,224 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mktmpio/go-mktmpio/stdcopy"
+	"golang.org/x/net/websocket"
+)
+
+// stdioServer serves websockets with handler, selecting the given stdio
+// subprotocol if the client offers it. An empty protocol acts like a server
+// that predates subprotocol negotiation.
+func stdioServer(protocol string, handler websocket.Handler) *httptest.Server {
+	return httptest.NewServer(websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			offered := cfg.Protocol
+			cfg.Protocol = nil
+			for _, p := range offered {
+				if p == protocol {
+					cfg.Protocol = []string{p}
+				}
+			}
+			return nil
+		},
+		Handler: handler,
+	})
+}
+
+// binaryStdin is input that contains the legacy EOF sentinel.
+var binaryStdin = append(append([]byte("a"), stdinEOF...), 'b')
+
+func TestAttachStdioFramed(t *testing.T) {
+	received := make(chan []byte, 1)
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		var stdin bytes.Buffer
+		_, err := stdcopy.StdinCopy(&stdin, ws)
+		if err != nil {
+			t.Error("reading framed stdin:", err)
+		}
+		received <- stdin.Bytes()
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	stdin, stdout, _, err := client.AttachStdioContext(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdio returned an error:", err)
+	}
+	stdin.Write(binaryStdin)
+	stdin.Close()
+	if data := <-received; !bytes.Equal(data, binaryStdin) {
+		t.Errorf("wrong stdin received: %q", data)
+	}
+	ioutil.ReadAll(stdout)
+}
+
+func TestAttachStdioLegacy(t *testing.T) {
+	received := make(chan []byte, 1)
+	ts := stdioServer("", func(ws *websocket.Conn) {
+		var stdin []byte
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				break
+			}
+			if bytes.Equal(data, stdinEOF) {
+				break
+			}
+			stdin = append(stdin, data...)
+		}
+		received <- stdin
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	stdin, stdout, _, err := client.AttachStdioContext(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdio returned an error:", err)
+	}
+	io.WriteString(stdin, "scan 0\n")
+	stdin.Close()
+	if data := <-received; string(data) != "scan 0\n" {
+		t.Errorf("wrong stdin received: %q", data)
+	}
+	ioutil.ReadAll(stdout)
+}
+
+func TestStdioSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler websocket.Handler
+		err     error
+	}{
+		{"clean", func(ws *websocket.Conn) {
+			stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("out"))
+			ws.Close()
+		}, nil},
+		{"dropped", func(ws *websocket.Conn) {
+			stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("out"))
+		}, ErrConnectionLost},
+		{"close status", func(ws *websocket.Conn) {
+			stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("out"))
+			frameCodec.Send(ws, frame{kind: websocket.CloseFrame, data: []byte("\x03\xf3boom")})
+		}, &CloseError{Code: 1011, Reason: "boom"}},
+		{"bad frame", func(ws *websocket.Conn) {
+			stdcopy.NewStdWriter(ws, stdcopy.Stdout).Write([]byte("out"))
+			ws.Write([]byte("\x09\x00\x00\x00\x00\x00\x00\x00"))
+			ws.Close()
+		}, errors.New("Unrecognized input header: 9")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+				ws.PayloadType = websocket.BinaryFrame
+				tt.handler(ws)
+			})
+			defer ts.Close()
+			client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+			s, err := client.AttachStdioSession(context.Background(), "12345678")
+			if err != nil {
+				t.Fatal("AttachStdioSession returned an error:", err)
+			}
+			out, readErr := ioutil.ReadAll(s.Stdout)
+			if string(out) != "out" {
+				t.Errorf("wrong stdout: %q", out)
+			}
+			err = s.Wait()
+			if fmt.Sprint(err) != fmt.Sprint(tt.err) || fmt.Sprint(readErr) != fmt.Sprint(tt.err) {
+				t.Errorf("expected %v, got %v from Wait and %v from stdout", tt.err, err, readErr)
+			}
+			if s.Err() != err {
+				t.Errorf("Err returned %v after Wait returned %v", s.Err(), err)
+			}
+		})
+	}
+}
+
+func TestStdioSessionDemuxError(t *testing.T) {
+	hungUp := make(chan struct{})
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		ws.Write([]byte("\x09\x00\x00\x00\x00\x00\x00\x00"))
+		io.Copy(ioutil.Discard, ws)
+		close(hungUp)
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	s, err := client.AttachStdioSession(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdioSession returned an error:", err)
+	}
+	if err := s.Wait(); err == nil {
+		t.Fatal("Wait did not return the demultiplexing error")
+	}
+	select {
+	case <-hungUp:
+	case <-time.After(5 * time.Second):
+		t.Error("connection left open after a demultiplexing error")
+	}
+	if _, err := s.Stdin.Write([]byte("more")); err == nil {
+		t.Error("stdin still accepted after a demultiplexing error")
+	}
+}
+
+func TestStdioSessionClose(t *testing.T) {
+	ts := stdioServer(stdioProtocolV2, func(ws *websocket.Conn) {
+		io.Copy(ioutil.Discard, ws)
+	})
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	s, err := client.AttachStdioSession(context.Background(), "12345678")
+	if err != nil {
+		t.Fatal("AttachStdioSession returned an error:", err)
+	}
+	s.Close()
+	if err := s.Wait(); err != nil {
+		t.Error("Wait returned an error after Close:", err)
+	}
+	if _, err := ioutil.ReadAll(s.Stderr); err != nil {
+		t.Error("stderr returned an error after Close:", err)
+	}
+}
+
+func TestWatchedConn(t *testing.T) {
+	var stream []byte
+	stream = append(stream, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"...)
+	// a binary frame with a 16 bit length, a masked text frame and an empty
+	// ping frame, all of which are ignored
+	stream = append(stream, 0x82, 126, 0x01, 0x00)
+	stream = append(stream, bytes.Repeat([]byte{0x88}, 256)...)
+	stream = append(stream, 0x81, 0x82, 1, 2, 3, 4, 'h', 'i')
+	stream = append(stream, 0x89, 0x00)
+	w := &watchedConn{}
+	w.follow(stream)
+	if err := w.closeErr(); err != ErrConnectionLost {
+		t.Fatal("expected ErrConnectionLost before close frame, got", err)
+	}
+	if p := w.protocol(); p != "" {
+		t.Errorf("no subprotocol was selected, got %q", p)
+	}
+	// the close frame, one byte at a time
+	for _, b := range []byte{0x88, 0x05, 0x0f, 0xa0, 'b', 'y', 'e'} {
+		w.follow([]byte{b})
+	}
+	var closeErr *CloseError
+	if err := w.closeErr(); !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Reason != "bye" {
+		t.Fatal("wrong close error:", err)
+	}
+	w.follow([]byte{0x88, 0x02, 0x03, 0xe8})
+	if err := w.closeErr(); err != nil {
+		t.Fatal("normal closure returned an error:", err)
+	}
+}
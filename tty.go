@@ -0,0 +1,347 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// TTYOptions holds the settings for a pseudo-TTY opened by AttachTTY.
+type TTYOptions struct {
+	// Rows and Cols are the initial size of the terminal window. If either
+	// is zero the server's default size is used.
+	Rows int
+	Cols int
+	// Reconnect, if set, makes the session re-dial the server when its
+	// connection is lost, making up to Reconnect.MaxAttempts attempts with
+	// backoff between them before failing with a *RetryError. If the server
+	// gave the session a token, the remote shell is resumed; otherwise a
+	// new shell is started.
+	Reconnect *RetryPolicy
+	// OnReconnect, if set, is called with each step of reconnecting. It is
+	// called synchronously and must not call the session's methods.
+	OnReconnect func(ReconnectEvent)
+}
+
+// ReconnectState is the kind of a ReconnectEvent.
+type ReconnectState int
+
+const (
+	// Reconnecting is reported before each attempt to reconnect.
+	Reconnecting ReconnectState = iota
+	// Reconnected is reported once an attempt succeeded.
+	Reconnected
+	// ReconnectFailed is reported when the session gives up.
+	ReconnectFailed
+)
+
+// ReconnectEvent reports progress reconnecting a TTYSession.
+type ReconnectEvent struct {
+	State   ReconnectState
+	Attempt int           // number of the attempt, starting at 1
+	Delay   time.Duration // wait before the attempt, for Reconnecting
+	// Err is why the connection was lost or the previous attempt failed.
+	// For ReconnectFailed it is the *RetryError the session fails with.
+	Err error
+	// Resumed is set for Reconnected if the session had a token from the
+	// server to resume the remote shell with, rather than starting a new
+	// one.
+	Resumed bool
+}
+
+// TTYSession is a remote shell attached to a pseudo-TTY. Reads and writes
+// carry the raw terminal stream, including control sequences.
+type TTYSession struct {
+	client  Client
+	ctx     context.Context
+	closing context.Context // done once the session is closed or ctx is done
+	cancel  func()
+	id      string
+	opts    TTYOptions
+
+	mu           sync.Mutex // guards the fields below
+	conn         *wsConn
+	stop         func()
+	token        string        // for resuming the remote shell, if the server sent one
+	err          error         // set once reconnecting failed
+	reconnecting chan struct{} // closed when the reconnect in progress ends
+	closed       bool
+
+	rmu sync.Mutex
+	buf []byte
+}
+
+// controlMessage is sent as a text frame alongside the binary terminal
+// stream to control the session.
+type controlMessage struct {
+	Type  string `json:"type"`
+	Rows  int    `json:"rows,omitempty"`
+	Cols  int    `json:"cols,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// frame is a single websocket message along with its payload type.
+type frame struct {
+	kind byte
+	data []byte
+}
+
+// frameCodec sends and receives whole websocket frames, preserving their
+// payload type.
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		f := v.(frame)
+		return f.data, f.kind, nil
+	},
+	Unmarshal: func(data []byte, kind byte, v interface{}) error {
+		f := v.(*frame)
+		f.kind, f.data = kind, data
+		return nil
+	},
+}
+
+// AttachTTY creates a remote shell for the instance identified by `id`
+// attached to a pseudo-TTY of the size given in opts, which can later be
+// changed using Resize. The session is closed when ctx is done.
+func (c Client) AttachTTY(ctx context.Context, id string, opts TTYOptions) (*TTYSession, error) {
+	s := &TTYSession{client: c, ctx: ctx, id: id, opts: opts}
+	s.closing, s.cancel = context.WithCancel(ctx)
+	conn, err := s.dial("")
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.conn, s.stop = conn, closeOnDone(ctx, conn)
+	return s, nil
+}
+
+// dial opens a websocket for the session, resuming the remote shell with
+// the given token if it is set.
+func (s *TTYSession) dial(token string) (*wsConn, error) {
+	params := url.Values{}
+	params.Set("id", s.id)
+	params.Set("stdio", "false")
+	s.mu.Lock()
+	rows, cols := s.opts.Rows, s.opts.Cols
+	s.mu.Unlock()
+	if rows > 0 && cols > 0 {
+		params.Set("rows", strconv.Itoa(rows))
+		params.Set("cols", strconv.Itoa(cols))
+	}
+	if token != "" {
+		params.Set("session", token)
+	}
+	return s.client.openWS(s.closing, params)
+}
+
+// current returns the session's current connection.
+func (s *TTYSession) current() *wsConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// Read reads terminal output from the remote shell.
+func (s *TTYSession) Read(p []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	for len(s.buf) == 0 {
+		conn := s.current()
+		var f frame
+		if err := frameCodec.Receive(conn.Conn, &f); err != nil {
+			if err == io.EOF {
+				if err = conn.closeErr(); err == nil {
+					return 0, io.EOF
+				}
+			}
+			if err = s.recover(conn, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if f.kind == websocket.TextFrame && s.control(f.data) {
+			continue
+		}
+		s.buf = f.data
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// control handles a text frame if it is a control message, reporting
+// whether it was one.
+func (s *TTYSession) control(data []byte) bool {
+	var msg controlMessage
+	if json.Unmarshal(data, &msg) != nil || msg.Type == "" {
+		return false
+	}
+	if msg.Type == "session" {
+		s.mu.Lock()
+		s.token = msg.Token
+		s.mu.Unlock()
+	}
+	return true
+}
+
+// Write sends terminal input to the remote shell.
+func (s *TTYSession) Write(p []byte) (int, error) {
+	conn := s.current()
+	n, err := conn.Write(p)
+	if err != nil {
+		if err = s.recover(conn, err); err != nil {
+			return n, err
+		}
+		return s.current().Write(p)
+	}
+	return n, nil
+}
+
+// Resize tells the remote shell that the terminal window has changed size.
+func (s *TTYSession) Resize(rows, cols int) error {
+	msg, err := json.Marshal(controlMessage{Type: "resize", Rows: rows, Cols: cols})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	// reconnecting asks for the latest size
+	s.opts.Rows, s.opts.Cols = rows, cols
+	conn := s.conn
+	s.mu.Unlock()
+	f := frame{kind: websocket.TextFrame, data: msg}
+	if err := frameCodec.Send(conn.Conn, f); err != nil {
+		if err = s.recover(conn, err); err != nil {
+			return err
+		}
+		return frameCodec.Send(s.current().Conn, f)
+	}
+	return nil
+}
+
+// Close ends the session, aborting any reconnect in progress.
+func (s *TTYSession) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conn, stop := s.conn, s.stop
+	s.mu.Unlock()
+	s.cancel()
+	stop()
+	return conn.Close()
+}
+
+// reconnectable reports whether a session whose connection failed with err
+// is worth reconnecting.
+func reconnectable(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, ErrConnectionLost) || errors.Is(err, ErrIdleTimeout) || errors.As(err, &opErr)
+}
+
+// recover handles the failure of conn with err, returning nil once the
+// session has a new connection or else the error the session fails with.
+// The session's lock is not held while waiting to reconnect, so Close can
+// abort the attempts; concurrent callers wait for the outcome instead.
+func (s *TTYSession) recover(conn *wsConn, err error) error {
+	s.mu.Lock()
+	for s.reconnecting != nil {
+		wait := s.reconnecting
+		s.mu.Unlock()
+		<-wait
+		s.mu.Lock()
+	}
+	switch {
+	case s.err != nil:
+		defer s.mu.Unlock()
+		return s.err
+	case s.conn != conn:
+		// already reconnected by another Read or Write
+		s.mu.Unlock()
+		return nil
+	case s.closed:
+		s.mu.Unlock()
+		return err
+	case s.ctx.Err() != nil:
+		s.mu.Unlock()
+		return s.ctx.Err()
+	case s.opts.Reconnect == nil || !reconnectable(err):
+		s.mu.Unlock()
+		return err
+	}
+	s.stop()
+	s.conn.Close()
+	done := make(chan struct{})
+	s.reconnecting = done
+	p := *s.opts.Reconnect
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.reconnecting = nil
+		s.mu.Unlock()
+		close(done)
+	}()
+	lost := err
+	var attempts []Attempt
+	for {
+		var delay time.Duration
+		if len(attempts) > 0 {
+			delay = p.backoff(len(attempts) + 1)
+			attempts[len(attempts)-1].Delay = delay
+		}
+		s.event(ReconnectEvent{State: Reconnecting, Attempt: len(attempts) + 1, Delay: delay, Err: err})
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.closing.Done():
+			timer.Stop()
+		case <-timer.C:
+			s.mu.Lock()
+			token := s.token
+			s.mu.Unlock()
+			var newConn *wsConn
+			if newConn, err = s.dial(token); err == nil {
+				s.mu.Lock()
+				if s.closed {
+					s.mu.Unlock()
+					newConn.Close()
+					return lost
+				}
+				s.conn, s.stop = newConn, closeOnDone(s.ctx, newConn)
+				s.mu.Unlock()
+				s.event(ReconnectEvent{State: Reconnected, Attempt: len(attempts) + 1, Resumed: token != ""})
+				return nil
+			}
+		}
+		if s.ctx.Err() != nil {
+			err = s.ctx.Err()
+		} else if s.closing.Err() != nil {
+			// closed by Close
+			return lost
+		}
+		attempts = append(attempts, Attempt{Err: err})
+		if s.ctx.Err() != nil || len(attempts) >= p.MaxAttempts {
+			break
+		}
+	}
+	s.mu.Lock()
+	s.err = &RetryError{Attempts: attempts, Err: err}
+	failed := s.err
+	s.mu.Unlock()
+	s.event(ReconnectEvent{State: ReconnectFailed, Attempt: len(attempts), Err: failed})
+	return failed
+}
+
+func (s *TTYSession) event(e ReconnectEvent) {
+	if s.opts.OnReconnect != nil {
+		s.opts.OnReconnect(e)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestAttachTTYResize(t *testing.T) {
+	resized := make(chan controlMessage, 1)
+	var query string
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		query = ws.Request().URL.RawQuery
+		ws.PayloadType = websocket.BinaryFrame
+		// a control message the client should not pass on as output
+		websocket.Message.Send(ws, `{"type": "hello"}`)
+		ws.Write([]byte("$ "))
+		for {
+			var f frame
+			if err := frameCodec.Receive(ws, &f); err != nil {
+				return
+			}
+			if f.kind == websocket.TextFrame {
+				var msg controlMessage
+				json.Unmarshal(f.data, &msg)
+				resized <- msg
+			} else {
+				ws.Write(f.data)
+			}
+		}
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	tty, err := client.AttachTTY(context.Background(), "12345678", TTYOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	defer tty.Close()
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(tty, buf); err != nil || string(buf) != "$ " {
+		t.Errorf("control message not filtered from output: %q %v", buf, err)
+	}
+	if query != "cols=80&id=12345678&rows=24&stdio=false" {
+		t.Error("initial size not requested:", query)
+	}
+	if err := tty.Resize(50, 132); err != nil {
+		t.Error("Resize returned an error:", err)
+	}
+	if msg := <-resized; msg.Type != "resize" || msg.Rows != 50 || msg.Cols != 132 {
+		t.Errorf("wrong resize message: %+v", msg)
+	}
+	io.WriteString(tty, "ls\r\n")
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(tty, buf); err != nil || string(buf) != "ls\r\n" {
+		t.Errorf("input not echoed: %q %v", buf, err)
+	}
+}
+
+func TestAttachTTYReconnectFailed(t *testing.T) {
+	var dials int32
+	wsHandler := websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		ws.Write([]byte("$ "))
+		// return without closing the websocket, as if the network failed
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&dials, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		wsHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	var events []ReconnectEvent
+	tty, err := client.AttachTTY(context.Background(), "12345678", TTYOptions{
+		Reconnect:   &fastRetries,
+		OnReconnect: func(e ReconnectEvent) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	defer tty.Close()
+	out, err := ioutil.ReadAll(tty)
+	if string(out) != "$ " {
+		t.Errorf("wrong output: %q", out)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Attempts) != fastRetries.MaxAttempts {
+		t.Fatalf("expected RetryError after %d attempts, got %v", fastRetries.MaxAttempts, err)
+	}
+	if _, err := tty.Write([]byte("ls\r")); err != retryErr {
+		t.Error("Write after giving up did not fail with the RetryError:", err)
+	}
+	var states []ReconnectState
+	for _, e := range events {
+		states = append(states, e.State)
+	}
+	if fmt.Sprint(states) != fmt.Sprint([]ReconnectState{Reconnecting, Reconnecting, Reconnecting, ReconnectFailed}) {
+		t.Errorf("wrong events: %+v", events)
+	}
+	if events[0].Err != ErrConnectionLost || events[0].Delay != 0 || events[1].Delay == 0 {
+		t.Errorf("wrong first events: %+v", events[:2])
+	}
+}
+
+func TestAttachTTYConnectionLost(t *testing.T) {
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		ws.Write([]byte("$ "))
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	tty, err := client.AttachTTY(context.Background(), "12345678", TTYOptions{})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	defer tty.Close()
+	if _, err := ioutil.ReadAll(tty); err != ErrConnectionLost {
+		t.Error("expected ErrConnectionLost without reconnecting, got", err)
+	}
+}
+
+func TestAttachTTYCloseWhileReconnecting(t *testing.T) {
+	var dials int32
+	wsHandler := websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		ws.Write([]byte("$ "))
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&dials, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		wsHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client, _ := NewClient(&Config{Token: "token", URL: ts.URL})
+	backingOff := make(chan struct{})
+	tty, err := client.AttachTTY(context.Background(), "12345678", TTYOptions{
+		Reconnect: &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour},
+		OnReconnect: func(e ReconnectEvent) {
+			if e.State == Reconnecting && e.Attempt == 2 {
+				close(backingOff)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal("AttachTTY returned an error:", err)
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(tty)
+		readErr <- err
+	}()
+	<-backingOff
+	closed := make(chan struct{})
+	go func() {
+		tty.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked while reconnecting")
+	}
+	select {
+	case err := <-readErr:
+		if err != ErrConnectionLost {
+			t.Error("expected Read to fail with ErrConnectionLost, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not abort reconnecting")
+	}
+}
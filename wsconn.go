@@ -0,0 +1,214 @@
+// Copyright Datajin Technologies, Inc. 2015,2017. All rights reserved.
+// Use of this source code is governed by an Artistic-2
+// license that can be found in the LICENSE file.
+
+package mktmpio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Websocket close statuses that mean the connection ended normally.
+const (
+	closeStatusNormal   = 1000
+	closeStatusNoStatus = 1005
+)
+
+// wsConn is a client websocket that knows how the server closed it.
+type wsConn struct {
+	*websocket.Conn
+	raw  *watchedConn
+	done chan struct{} // closed by Close
+	once sync.Once
+}
+
+// Close closes the websocket.
+func (c *wsConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// closeErr returns the reason the websocket ended once reads from it have
+// hit EOF: nil if the server closed it normally, a *CloseError if it closed
+// it with an error status or ErrConnectionLost if it never closed it.
+func (c *wsConn) closeErr() error {
+	return c.raw.closeErr()
+}
+
+// watchedConn is the connection under a client websocket. It follows the
+// frames sent by the server to catch its close frame, which
+// x/net/websocket reads but does not expose.
+type watchedConn struct {
+	net.Conn
+	timeout time.Duration // for each read and write, if set
+
+	// only used by Read, which x/net/websocket never calls concurrently
+	upgraded  bool   // done with the HTTP handshake response
+	response  []byte // the HTTP handshake response, up to maxResponse
+	crlf      int    // length of the "\r\n\r\n" suffix read so far
+	header    []byte // frame header read so far
+	inPayload bool
+	remaining uint64 // payload bytes left in the current frame
+	closing   bool   // the current frame is a close frame
+	payload   []byte // close frame payload read so far
+
+	mu     sync.Mutex
+	closed *CloseError // close frame received from the server, if any
+}
+
+func (w *watchedConn) Read(p []byte) (int, error) {
+	if w.timeout > 0 {
+		w.Conn.SetReadDeadline(time.Now().Add(w.timeout))
+	}
+	n, err := w.Conn.Read(p)
+	w.follow(p[:n])
+	return n, timeoutErr(err)
+}
+
+func (w *watchedConn) Write(p []byte) (int, error) {
+	if w.timeout > 0 {
+		w.Conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	n, err := w.Conn.Write(p)
+	return n, timeoutErr(err)
+}
+
+// timeoutErr replaces deadline errors with ErrIdleTimeout.
+func timeoutErr(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrIdleTimeout
+	}
+	return err
+}
+
+// maxResponse is how much of the HTTP handshake response watchedConn keeps.
+const maxResponse = 16 * 1024
+
+// protocol returns the subprotocol selected by the server in its handshake
+// response, or "" if it did not select one. x/net/websocket leaves the
+// offered subprotocols in place when the server does not select any, so
+// that its Config can't tell the two apart.
+func (w *watchedConn) protocol() string {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(w.response)), nil)
+	if err != nil {
+		return ""
+	}
+	return resp.Header.Get("Sec-WebSocket-Protocol")
+}
+
+// follow advances through the server's side of the connection by the
+// bytes in p.
+func (w *watchedConn) follow(p []byte) {
+	for len(p) > 0 {
+		if !w.upgraded {
+			if len(w.response) < maxResponse {
+				w.response = append(w.response, p[0])
+			}
+			switch {
+			case p[0] == "\r\n\r\n"[w.crlf]:
+				w.crlf++
+			case p[0] == '\r':
+				w.crlf = 1
+			default:
+				w.crlf = 0
+			}
+			w.upgraded = w.crlf == 4
+			p = p[1:]
+			continue
+		}
+		if !w.inPayload {
+			w.header = append(w.header, p[0])
+			p = p[1:]
+			if len(w.header) < frameHeaderLen(w.header) {
+				continue
+			}
+			w.startFrame()
+			continue
+		}
+		n := uint64(len(p))
+		if n > w.remaining {
+			n = w.remaining
+		}
+		if w.closing && len(w.payload) < 125 {
+			w.payload = append(w.payload, p[:n]...)
+		}
+		w.remaining -= n
+		p = p[n:]
+		if w.remaining == 0 {
+			w.endFrame()
+		}
+	}
+}
+
+// frameHeaderLen returns the length of the frame header starting with the
+// given bytes, as far as can be told from them.
+func frameHeaderLen(header []byte) int {
+	if len(header) < 2 {
+		return 2
+	}
+	n := 2
+	switch header[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if header[1]&0x80 != 0 {
+		n += 4 // masking key
+	}
+	return n
+}
+
+func (w *watchedConn) startFrame() {
+	h := w.header
+	w.header = w.header[:0]
+	w.closing = h[0]&0x0f == websocket.CloseFrame
+	w.payload = w.payload[:0]
+	switch h[1] & 0x7f {
+	case 126:
+		w.remaining = uint64(binary.BigEndian.Uint16(h[2:]))
+	case 127:
+		w.remaining = binary.BigEndian.Uint64(h[2:])
+	default:
+		w.remaining = uint64(h[1] & 0x7f)
+	}
+	w.inPayload = true
+	if w.remaining == 0 {
+		w.endFrame()
+	}
+}
+
+func (w *watchedConn) endFrame() {
+	w.inPayload = false
+	if !w.closing {
+		return
+	}
+	closed := &CloseError{Code: closeStatusNoStatus}
+	if len(w.payload) >= 2 {
+		closed.Code = int(binary.BigEndian.Uint16(w.payload))
+		closed.Reason = string(w.payload[2:])
+	}
+	w.mu.Lock()
+	w.closed = closed
+	w.mu.Unlock()
+}
+
+func (w *watchedConn) closeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case w.closed == nil:
+		return ErrConnectionLost
+	case w.closed.Code == closeStatusNormal || w.closed.Code == closeStatusNoStatus:
+		return nil
+	}
+	return w.closed
+}